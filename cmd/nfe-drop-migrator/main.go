@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
@@ -15,7 +17,24 @@ import (
 	"nfe-drop/internal/migrations"
 )
 
+// subcommands são os comandos versionados de migration (goose/golang-migrate
+// style). Quando informados como primeiro argumento, eles operam diretamente
+// no banco da aplicação e não passam pelo fluxo de criação/drop de banco.
+var subcommands = map[string]bool{
+	"up":     true,
+	"down":   true,
+	"status": true,
+	"redo":   true,
+	"goto":   true,
+	"force":  true,
+}
+
 func main() {
+	if len(os.Args) > 1 && subcommands[os.Args[1]] {
+		runMigrationSubcommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Flags:
 	// --auto  => modo não interativo (para Ansible)
 	// --force => só faz sentido em modo manual: dropa e recria DB existente
@@ -124,7 +143,8 @@ func main() {
 	runAppMigrationsOrDie(cfg)
 }
 
-// runAppMigrationsOrDie conecta no banco da aplicação e roda migrations.Run.
+// runAppMigrationsOrDie conecta no banco da aplicação e aplica todas as
+// migrations pendentes (migrations.Up com n=0).
 func runAppMigrationsOrDie(cfg *config.Config) {
 	appDB, err := sql.Open("pgx", cfg.AppDSN())
 	if err != nil {
@@ -138,13 +158,127 @@ func runAppMigrationsOrDie(cfg *config.Config) {
 
 	log.Println("Conectado ao banco da aplicação. Aplicando migrations...")
 
-	if err := migrations.Run(appDB); err != nil {
+	if err := migrations.Up(appDB, 0); err != nil {
 		log.Fatalf("erro executando migrations: %v", err)
 	}
 
 	log.Println("Migrations aplicadas com sucesso. Banco pronto para uso.")
 }
 
+// runMigrationSubcommand conecta direto no banco da aplicação e despacha
+// para o comando versionado de migration correspondente (up/down/status/
+// redo/goto/force), sem passar pelo fluxo de criação/drop de banco.
+func runMigrationSubcommand(cmd string, args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("erro carregando configuração: %v", err)
+	}
+
+	appDB, err := sql.Open("pgx", cfg.AppDSN())
+	if err != nil {
+		log.Fatalf("erro conectando ao banco da aplicação: %v", err)
+	}
+	defer appDB.Close()
+
+	if err := appDB.Ping(); err != nil {
+		log.Fatalf("erro no ping ao banco da aplicação: %v", err)
+	}
+
+	switch cmd {
+	case "up":
+		n := 0
+		if len(args) > 0 {
+			n, err = strconv.Atoi(args[0])
+			if err != nil {
+				log.Fatalf("N inválido: %v", err)
+			}
+		}
+		if err := migrations.Up(appDB, n); err != nil {
+			log.Fatalf("erro aplicando migrations: %v", err)
+		}
+		log.Println("migrations aplicadas com sucesso.")
+
+	case "down":
+		n := 1
+		if len(args) > 0 {
+			n, err = strconv.Atoi(args[0])
+			if err != nil {
+				log.Fatalf("N inválido: %v", err)
+			}
+		}
+		if err := migrations.Down(appDB, n); err != nil {
+			log.Fatalf("erro revertendo migrations: %v", err)
+		}
+		log.Println("rollback concluído.")
+
+	case "status":
+		entries, err := migrations.Status(appDB)
+		if err != nil {
+			log.Fatalf("erro consultando status das migrations: %v", err)
+		}
+		printMigrationStatus(entries)
+
+	case "redo":
+		if err := migrations.Redo(appDB); err != nil {
+			log.Fatalf("erro refazendo última migration: %v", err)
+		}
+		log.Println("migration refeita com sucesso.")
+
+	case "goto":
+		if len(args) < 1 {
+			log.Fatalf("uso: nfe-drop-migrator goto <version>")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalf("versão inválida: %v", err)
+		}
+		if err := migrations.Goto(appDB, version); err != nil {
+			log.Fatalf("erro movendo banco para a versão %d: %v", version, err)
+		}
+		log.Println("banco movido para a versão solicitada.")
+
+	case "force":
+		if len(args) < 1 {
+			log.Fatalf("uso: nfe-drop-migrator force <version>")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalf("versão inválida: %v", err)
+		}
+		if err := migrations.Force(appDB, version); err != nil {
+			log.Fatalf("erro forçando versão %d: %v", version, err)
+		}
+		log.Println("versão forçada com sucesso.")
+	}
+}
+
+// printMigrationStatus imprime uma linha por migration conhecida, com estado
+// (aplicada/pendente) e sinalizadores de dirty/checksum divergente, pra
+// diagnóstico sem precisar dropar o banco.
+func printMigrationStatus(entries []migrations.StatusEntry) {
+	for _, e := range entries {
+		state := "pendente"
+		if e.Applied {
+			state = fmt.Sprintf("aplicada em %s", e.AppliedAt.Format(time.RFC3339))
+		}
+
+		var flags []string
+		if e.Dirty {
+			flags = append(flags, "DIRTY")
+		}
+		if e.ChecksumMismatch {
+			flags = append(flags, "CHECKSUM-MISMATCH")
+		}
+
+		suffix := ""
+		if len(flags) > 0 {
+			suffix = "  [" + strings.Join(flags, ", ") + "]"
+		}
+
+		fmt.Printf("%04d  %-40s  %s%s\n", e.Version, e.Description, state, suffix)
+	}
+}
+
 func databaseExists(db *sql.DB, name string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1);`
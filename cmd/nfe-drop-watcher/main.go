@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -14,15 +15,14 @@ import (
 )
 
 func main() {
-	logx.Init()
-	slog.Info("[nfe-drop-watcher] iniciando...")
-
 	cfg, err := config.Load()
 	if err != nil {
-		slog.Error("erro carregando config", "err", err)
-		os.Exit(1)
+		log.Fatalf("erro carregando config: %v", err)
 	}
 
+	logx.Init(cfg)
+	slog.Info("[nfe-drop-watcher] iniciando...")
+
 	// inicia métricas Prometheus
 	metrics.Init()
 	metricsAddr := os.Getenv("NFE_DROP_METRICS_ADDR_WATCHER")
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"log"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -10,6 +11,7 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
+	"nfe-drop/internal/admin"
 	"nfe-drop/internal/config"
 	"nfe-drop/internal/logx"
 	"nfe-drop/internal/metrics"
@@ -17,15 +19,14 @@ import (
 )
 
 func main() {
-	logx.Init()
-	slog.Info("[nfe-drop-worker] iniciando...")
-
 	cfg, err := config.Load()
 	if err != nil {
-		slog.Error("erro carregando config", "err", err)
-		os.Exit(1)
+		log.Fatalf("erro carregando config: %v", err)
 	}
 
+	logx.Init(cfg)
+	slog.Info("[nfe-drop-worker] iniciando...")
+
 	db, err := sql.Open("pgx", cfg.AppDSN())
 	if err != nil {
 		slog.Error("erro abrindo conexão com banco da aplicação", "err", err)
@@ -51,6 +52,15 @@ func main() {
 	defer stop()
 
 	w := worker.New(cfg, db)
+
+	// API administrativa (reprocessamento, reingestão, consulta de NFe,
+	// backup sob demanda e profundidade de filas). Compartilha o mesmo *sql.DB
+	// e queue.Broker do worker em vez de abrir conexões próprias.
+	adminAddr := os.Getenv("NFE_DROP_ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9102"
+	}
+	admin.New(cfg, db, w.Broker()).Start(adminAddr)
 	if err := w.Run(ctx); err != nil && err != context.Canceled {
 		slog.Error("worker finalizou com erro", "err", err)
 		os.Exit(1)
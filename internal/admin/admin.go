@@ -0,0 +1,291 @@
+// Package admin expõe uma API HTTP administrativa (reprocessamento,
+// reingestão de arquivos falhos, consulta de NFe, backup sob demanda e
+// profundidade de filas), protegida por um bearer token fixo em
+// NFE_DROP_ADMIN_TOKEN. Pensado pra rodar ao lado do /metrics existente
+// (ver internal/metrics), não em substituição a ele.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nfe-drop/internal/backup"
+	"nfe-drop/internal/config"
+	"nfe-drop/internal/nfe"
+	"nfe-drop/internal/queue"
+	"nfe-drop/internal/storage"
+)
+
+// Server agrupa as dependências dos endpoints administrativos. broker pode
+// ser nil (nenhuma fila configurada), caso em que /admin/backup só aceita
+// mode=now e /admin/queue/stats responde 503.
+type Server struct {
+	cfg    *config.Config
+	db     *sql.DB
+	broker queue.Broker
+	token  string
+}
+
+func New(cfg *config.Config, db *sql.DB, broker queue.Broker) *Server {
+	return &Server{
+		cfg:    cfg,
+		db:     db,
+		broker: broker,
+		token:  os.Getenv("NFE_DROP_ADMIN_TOKEN"),
+	}
+}
+
+// Start sobe o servidor administrativo em addr (ex: ":9102"). Se
+// NFE_DROP_ADMIN_TOKEN não estiver configurado, os endpoints respondem 503
+// em vez de ficar expostos sem autenticação.
+func (s *Server) Start(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/reprocess", s.authenticated(s.handleReprocess))
+	mux.HandleFunc("/admin/reingest", s.authenticated(s.handleReingest))
+	mux.HandleFunc("/admin/nfe/", s.authenticated(s.handleGetNFe))
+	mux.HandleFunc("/admin/backup", s.authenticated(s.handleBackup))
+	mux.HandleFunc("/admin/queue/stats", s.authenticated(s.handleQueueStats))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		slog.Info("iniciando servidor administrativo", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("erro no servidor administrativo", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// authenticated envolve um handler exigindo "Authorization: Bearer <token>"
+// igual a NFE_DROP_ADMIN_TOKEN, comparado em tempo constante pra evitar
+// side-channel de timing.
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusServiceUnavailable, "NFE_DROP_ADMIN_TOKEN não configurado; API administrativa desabilitada")
+			return
+		}
+
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, prefix)), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "não autorizado")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("erro serializando resposta JSON da API administrativa", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// ----------------------------------------------------------------------
+// POST /admin/reprocess?chave=...
+// ----------------------------------------------------------------------
+
+// handleReprocess busca o XML já armazenado para a chave informada,
+// reparseia (nfe.ParseBytes) e substitui o registro existente via
+// storage.UpsertNFeWithRelations. Útil depois de um fix no parser, quando
+// vale a pena reprocessar NFe já persistidas sem reenviar o arquivo original
+// pelo pipeline de ingestão.
+func (s *Server) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "método não permitido")
+		return
+	}
+
+	chave := r.URL.Query().Get("chave")
+	if chave == "" {
+		writeError(w, http.StatusBadRequest, "parâmetro chave é obrigatório")
+		return
+	}
+
+	raw, err := storage.GetXMLRawByChave(s.db, chave)
+	if err != nil {
+		if errors.Is(err, storage.ErrNFeNotFound) {
+			writeError(w, http.StatusNotFound, "NFe não encontrada")
+			return
+		}
+		slog.Error("erro buscando xml_raw para reprocessamento", "chave", chave, "err", err)
+		writeError(w, http.StatusInternalServerError, "erro lendo NFe armazenada")
+		return
+	}
+
+	parsed, err := nfe.ParseBytes(raw, "nfe_xml:"+chave)
+	if err != nil {
+		slog.Error("erro reparseando XML armazenado", "chave", chave, "err", err)
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("erro reparseando XML: %v", err))
+		return
+	}
+
+	nfeID, err := storage.UpsertNFeWithRelations(s.db, parsed)
+	if err != nil {
+		slog.Error("erro reprocessando NFe (upsert)", "chave", chave, "err", err)
+		writeError(w, http.StatusInternalServerError, "erro persistindo NFe reprocessada")
+		return
+	}
+
+	slog.Info("NFe reprocessada via API administrativa", "chave", chave, "nfe_id", nfeID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"chave": chave, "nfe_id": nfeID})
+}
+
+// ----------------------------------------------------------------------
+// POST /admin/reingest?path=...
+// ----------------------------------------------------------------------
+
+// handleReingest move um arquivo de FailedDir de volta para IncomingDir, pra
+// que o pipeline normal de ingestão tente processá-lo de novo. Só o nome
+// base de path é considerado, pra não permitir escapar de FailedDir via
+// path traversal.
+func (s *Server) handleReingest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "método não permitido")
+		return
+	}
+
+	raw := r.URL.Query().Get("path")
+	if raw == "" {
+		writeError(w, http.StatusBadRequest, "parâmetro path é obrigatório")
+		return
+	}
+
+	filename := filepath.Base(raw)
+	srcPath := filepath.Join(s.cfg.FailedDir, filename)
+	destPath := filepath.Join(s.cfg.IncomingDir, filename)
+
+	if err := os.Rename(srcPath, destPath); err != nil {
+		slog.Error("erro reingerindo arquivo de failed para incoming", "src", srcPath, "dest", destPath, "err", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("erro movendo arquivo: %v", err))
+		return
+	}
+
+	// sidecar de erro não faz mais sentido depois da reingestão; melhor
+	// esforço, não falha a requisição se não existir.
+	_ = os.Remove(srcPath + ".error.json")
+
+	slog.Info("arquivo reingerido via API administrativa", "src", srcPath, "dest", destPath)
+	writeJSON(w, http.StatusOK, map[string]string{"filename": filename, "dest": destPath})
+}
+
+// ----------------------------------------------------------------------
+// GET /admin/nfe/{chave}
+// ----------------------------------------------------------------------
+
+func (s *Server) handleGetNFe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "método não permitido")
+		return
+	}
+
+	chave := strings.TrimPrefix(r.URL.Path, "/admin/nfe/")
+	if chave == "" {
+		writeError(w, http.StatusBadRequest, "chave de acesso é obrigatória na URL")
+		return
+	}
+
+	detail, err := storage.GetNFeDetail(s.db, chave)
+	if err != nil {
+		if errors.Is(err, storage.ErrNFeNotFound) {
+			writeError(w, http.StatusNotFound, "NFe não encontrada")
+			return
+		}
+		slog.Error("erro buscando detalhe de NFe", "chave", chave, "err", err)
+		writeError(w, http.StatusInternalServerError, "erro lendo NFe")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// ----------------------------------------------------------------------
+// POST /admin/backup?mode=now|enqueue
+// ----------------------------------------------------------------------
+
+// handleBackup executa um dump do banco na hora (mode=now, padrão) ou
+// enfileira um job "backup" pro worker executar de forma assíncrona
+// (mode=enqueue, exige uma fila habilitada).
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "método não permitido")
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "now"
+	}
+
+	switch mode {
+	case "now":
+		path, err := backup.Run(r.Context(), s.cfg)
+		if err != nil {
+			slog.Error("erro executando backup imediato", "err", err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("erro executando pg_dump: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"mode": "now", "path": path})
+
+	case "enqueue":
+		if s.broker == nil {
+			writeError(w, http.StatusServiceUnavailable, "fila não está habilitada; use mode=now")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := s.broker.PublishJob(ctx, queue.Job{Kind: "backup"}); err != nil {
+			slog.Error("erro enfileirando job de backup", "err", err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("erro enfileirando backup: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"mode": "enqueue"})
+
+	default:
+		writeError(w, http.StatusBadRequest, "mode inválido (use now ou enqueue)")
+	}
+}
+
+// ----------------------------------------------------------------------
+// GET /admin/queue/stats
+// ----------------------------------------------------------------------
+
+func (s *Server) handleQueueStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "método não permitido")
+		return
+	}
+
+	if s.broker == nil {
+		writeError(w, http.StatusServiceUnavailable, "fila não está habilitada")
+		return
+	}
+
+	stats, err := s.broker.Stats()
+	if err != nil {
+		slog.Error("erro consultando profundidade das filas", "err", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("erro consultando filas: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,52 @@
+// Package backup executa o dump do banco da aplicação via pg_dump, usado
+// tanto pelo caminho síncrono do admin (POST /admin/backup?mode=now) quanto
+// pelo job assíncrono "backup" consumido pelo worker (mode=enqueue).
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nfe-drop/internal/config"
+)
+
+// Run executa pg_dump do banco da aplicação (cfg.DBName) e grava o dump em
+// NFE_DROP_BACKUP_DIR (padrão "<project_dir>/backup"), nomeando o arquivo com
+// o nome do banco e o timestamp da execução. Devolve o path do arquivo
+// gerado.
+func Run(ctx context.Context, cfg *config.Config) (string, error) {
+	dir := os.Getenv("NFE_DROP_BACKUP_DIR")
+	if dir == "" {
+		dir = filepath.Join(cfg.ProjectDir, "backup")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("erro criando diretório de backup: %w", err)
+	}
+
+	filename := fmt.Sprintf("%s_%s.sql", cfg.DBName, time.Now().UTC().Format("20060102T150405Z"))
+	destPath := filepath.Join(dir, filename)
+
+	cmd := exec.CommandContext(ctx, "pg_dump",
+		"--host", cfg.DBHost,
+		"--port", strconv.Itoa(cfg.DBPort),
+		"--username", cfg.DBUser,
+		"--format", "plain",
+		"--file", destPath,
+		cfg.DBName,
+	)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.DBPass)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_dump falhou: %w (saída: %s)", err, strings.TrimSpace(string(out)))
+	}
+
+	slog.Info("backup do banco concluído", "path", destPath)
+	return destPath, nil
+}
@@ -3,14 +3,75 @@ package logx
 import (
 	"log/slog"
 	"os"
+	"strings"
+
+	"nfe-drop/internal/config"
 )
 
 var Logger *slog.Logger
 
-func Init() {
-	// Saída em JSON no stdout
+// traceCategories guarda as categorias habilitadas via NFE_DROP_TRACE, ex:
+// "zip,parse,db,queue,fsnotify" ou "all".
+var traceCategories map[string]bool
+
+// Init configura o logger global a partir do LogLevel de cfg (debug|info|warn|error,
+// default info se vazio ou desconhecido) e carrega as categorias de NFE_DROP_TRACE.
+func Init(cfg *config.Config) {
+	level := slog.LevelInfo
+	if cfg != nil {
+		level = parseLevel(cfg.LogLevel)
+	}
+
 	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	})
 	Logger = slog.New(handler)
+	slog.SetDefault(Logger)
+
+	traceCategories = parseTraceCategories(os.Getenv("NFE_DROP_TRACE"))
+}
+
+func parseLevel(logLevel string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(logLevel)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "info", "":
+		return slog.LevelInfo
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func parseTraceCategories(raw string) map[string]bool {
+	cats := map[string]bool{}
+	for _, c := range strings.Split(raw, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" {
+			cats[c] = true
+		}
+	}
+	return cats
+}
+
+// Enabled indica se a categoria `cat` está habilitada via NFE_DROP_TRACE
+// (ou se "all" foi habilitado).
+func Enabled(cat string) bool {
+	if traceCategories == nil {
+		return false
+	}
+	return traceCategories["all"] || traceCategories[strings.ToLower(cat)]
+}
+
+// Trace loga em nível debug, mas só monta a mensagem/atributos se a categoria
+// `cat` estiver habilitada em NFE_DROP_TRACE — evita custo em hot paths (ex:
+// por-XML dentro de processZIP) quando o trace está desligado.
+func Trace(cat, msg string, args ...any) {
+	if !Enabled(cat) || Logger == nil {
+		return
+	}
+	Logger.Debug(msg, append([]any{"trace_cat", cat}, args...)...)
 }
@@ -27,11 +27,44 @@ var (
 		},
 		[]string{"status", "source"},
 	)
+
+	jobRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nfe_job_retries_total",
+			Help: "Quantidade de vezes que um job foi reenfileirado para nova tentativa.",
+		},
+		[]string{"queue"},
+	)
+
+	jobDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nfe_job_dead_lettered_total",
+			Help: "Quantidade de jobs que esgotaram as tentativas e foram para a dead-letter queue.",
+		},
+		[]string{"queue"},
+	)
+
+	zipInProgress = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nfe_zip_in_progress",
+			Help: "Fração (0 a 1) de entradas já processadas do ZIP em andamento, por arquivo.",
+		},
+		[]string{"zip"},
+	)
+
+	zipDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nfe_zip_duration_seconds",
+			Help:    "Tempo total de processamento de um ZIP, do início ao fim (ou cancelamento).",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"status"}, // status: completed|cancelled
+	)
 )
 
 // Init registra as métricas no registry global.
 func Init() {
-	prometheus.MustRegister(nfeProcessed, nfeDuration)
+	prometheus.MustRegister(nfeProcessed, nfeDuration, jobRetries, jobDeadLettered, zipInProgress, zipDuration)
 }
 
 // ObserveNFe registra o resultado de uma NF-e processada.
@@ -44,6 +77,33 @@ func ObserveNFe(status, source string, d time.Duration) {
 	nfeDuration.With(labels).Observe(d.Seconds())
 }
 
+// ObserveJobRetry registra que um job de `queue` foi reenfileirado.
+func ObserveJobRetry(queue string) {
+	jobRetries.With(prometheus.Labels{"queue": queue}).Inc()
+}
+
+// ObserveJobDeadLettered registra que um job de `queue` esgotou as tentativas.
+func ObserveJobDeadLettered(queue string) {
+	jobDeadLettered.With(prometheus.Labels{"queue": queue}).Inc()
+}
+
+// SetZipProgress atualiza a fração (0 a 1) de entradas já processadas do ZIP
+// `zip` em andamento.
+func SetZipProgress(zip string, fraction float64) {
+	zipInProgress.With(prometheus.Labels{"zip": zip}).Set(fraction)
+}
+
+// DeleteZipProgress remove a série do gauge quando o ZIP termina (com sucesso
+// ou cancelamento), pra não acumular séries de arquivos que já foram embora.
+func DeleteZipProgress(zip string) {
+	zipInProgress.Delete(prometheus.Labels{"zip": zip})
+}
+
+// ObserveZipDuration registra a duração total do processamento de um ZIP.
+func ObserveZipDuration(status string, d time.Duration) {
+	zipDuration.With(prometheus.Labels{"status": status}).Observe(d.Seconds())
+}
+
 // StartHTTPServer sobe um /metrics na porta indicada (ex: ":9101").
 func StartHTTPServer(addr string) {
 	mux := http.NewServeMux()
@@ -1,180 +1,622 @@
+// Package migrations implementa um runner de migrations versionadas ao
+// estilo goose/golang-migrate: arquivos NNNN_descricao.up.sql/.down.sql
+// embutidos no binário via embed.FS, rastreados na tabela schema_migrations
+// (versão, checksum e applied_at), com advisory lock do Postgres pra que
+// múltiplas execuções do migrator não apliquem migrations em paralelo.
+//
+// O runner é Postgres-only de propósito: as migrations embutidas (sql/*.sql)
+// são SQL Postgres puro, não templates renderizados por um Dialect. O
+// restante do serviço também já depende de recursos exclusivos de Postgres
+// (COPY em lote via pgx em storage.SaveNFeBatch, pg_advisory_lock serializando
+// o migrator, particionamento nativo RANGE introduzido em
+// 0004_partition_nfe_by_emissao), então uma camada de Dialect para
+// SQLite/MySQL seria um contrato sem implementação real atrás — suporte pela
+// metade que falha em produção de forma silenciosa, pior do que não ter a
+// opção.
 package migrations
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"embed"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Run executa todas as migrations necessárias no banco da aplicação.
-func Run(db *sql.DB) error {
-	stmts := []string{
-		// nfe
-		`
-CREATE TABLE IF NOT EXISTS nfe (
-    id BIGSERIAL PRIMARY KEY,
-    chave_acesso CHAR(44) NOT NULL,
-    hash_integridade CHAR(64) NOT NULL,
-
-    modelo SMALLINT NOT NULL,
-    serie INTEGER NOT NULL,
-    numero INTEGER NOT NULL,
-    emissao TIMESTAMP(3) NOT NULL,
-    tipo_operacao SMALLINT NOT NULL,
-    tipo_ambiente SMALLINT NOT NULL,
-    natureza_operacao VARCHAR(255) NOT NULL,
-
-    protocolo_autorizacao VARCHAR(50),
-    data_autorizacao TIMESTAMP(3),
-    codigo_status SMALLINT,
-
-    emitente_cnpj CHAR(14) NOT NULL,
-    emitente_razao VARCHAR(255) NOT NULL,
-    dest_cnpj_cpf CHAR(14),
-    dest_razao VARCHAR(255),
-
-    valor_total_nota NUMERIC(15,2) NOT NULL,
-    valor_produtos NUMERIC(15,2) NOT NULL,
-    valor_desconto NUMERIC(15,2) DEFAULT 0,
-    valor_icms NUMERIC(15,2) DEFAULT 0,
-    valor_ipi NUMERIC(15,2) DEFAULT 0,
-    valor_pis NUMERIC(15,2) DEFAULT 0,
-    valor_cofins NUMERIC(15,2) DEFAULT 0,
-    valor_ii NUMERIC(15,2) DEFAULT 0,
-    valor_frete NUMERIC(15,2) DEFAULT 0,
-    valor_seguro NUMERIC(15,2) DEFAULT 0,
-
-    modalidade_frete SMALLINT,
-
-    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-
-    CONSTRAINT uk_nfe_chave_acesso UNIQUE (chave_acesso),
-    CONSTRAINT uk_nfe_hash_integridade UNIQUE (hash_integridade)
-);
-`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_emissao ON nfe (emissao);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_emitente_cnpj ON nfe (emitente_cnpj);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_dest_cnpj_cpf ON nfe (dest_cnpj_cpf);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_serie_numero ON nfe (serie, numero);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_emitente_emissao ON nfe (emitente_cnpj, emissao);`,
-
-		// nfe_xml
-		`
-CREATE TABLE IF NOT EXISTS nfe_xml (
-    nfe_id BIGINT PRIMARY KEY,
-    xml_raw TEXT NOT NULL,
-    xml_json JSONB,
-
-    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-
-    CONSTRAINT fk_nfe_xml_nfe
-        FOREIGN KEY (nfe_id) REFERENCES nfe(id)
-        ON DELETE CASCADE
-);
-`,
-
-		// nfe_item
-		`
-CREATE TABLE IF NOT EXISTS nfe_item (
-    id BIGSERIAL PRIMARY KEY,
-    nfe_id BIGINT NOT NULL,
-    n_item INTEGER NOT NULL,
-
-    codigo VARCHAR(100),
-    codigo_ean VARCHAR(14),
-    descricao VARCHAR(255),
-    ncm CHAR(8),
-    cfop CHAR(4),
-    unidade VARCHAR(10),
-
-    quantidade NUMERIC(15,4) NOT NULL,
-    valor_unit NUMERIC(21,10) NOT NULL,
-    valor_total_bruto NUMERIC(15,2) NOT NULL,
-
-    valor_frete NUMERIC(15,2) DEFAULT 0,
-    valor_seguro NUMERIC(15,2) DEFAULT 0,
-    valor_desconto NUMERIC(15,2) DEFAULT 0,
-    valor_outros NUMERIC(15,2) DEFAULT 0,
-    ind_total SMALLINT NOT NULL,
-
-    base_calculo_icms NUMERIC(15,2) DEFAULT 0,
-    valor_icms NUMERIC(15,2) DEFAULT 0,
-    base_calculo_icms_st NUMERIC(15,2) DEFAULT 0,
-    valor_icms_st NUMERIC(15,2) DEFAULT 0,
-    valor_ipi NUMERIC(15,2) DEFAULT 0,
-    valor_pis NUMERIC(15,2) DEFAULT 0,
-    valor_cofins NUMERIC(15,2) DEFAULT 0,
-
-    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-
-    CONSTRAINT uk_nfe_item UNIQUE (nfe_id, n_item),
-    CONSTRAINT fk_nfe_item_nfe
-        FOREIGN KEY (nfe_id) REFERENCES nfe(id)
-        ON DELETE CASCADE
-);
-`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_item_nfe ON nfe_item (nfe_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_item_ncm ON nfe_item (ncm);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_item_cfop ON nfe_item (cfop);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_item_codigo ON nfe_item (codigo);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_item_descricao ON nfe_item (descricao);`,
-
-		// nfe_duplicatas
-		`
-CREATE TABLE IF NOT EXISTS nfe_duplicatas (
-    id BIGSERIAL PRIMARY KEY,
-    nfe_id BIGINT NOT NULL,
-
-    numero_duplicata VARCHAR(60),
-    data_vencimento DATE,
-    valor_duplicata NUMERIC(15,2) NOT NULL,
-
-    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-
-    CONSTRAINT uk_nfe_duplicata UNIQUE (nfe_id, numero_duplicata),
-    CONSTRAINT fk_nfe_duplicata_nfe
-        FOREIGN KEY (nfe_id) REFERENCES nfe(id)
-        ON DELETE CASCADE
-);
-`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_duplicatas_nfe ON nfe_duplicatas (nfe_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_duplicatas_vencimento ON nfe_duplicatas (data_vencimento);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_duplicatas_numero ON nfe_duplicatas (numero_duplicata);`,
-
-		// nfe_pagamentos
-		`
-CREATE TABLE IF NOT EXISTS nfe_pagamentos (
-    id BIGSERIAL PRIMARY KEY,
-    nfe_id BIGINT NOT NULL,
-
-    indicador_pagamento SMALLINT,
-    meio_pagamento VARCHAR(150) NOT NULL,
-    valor_pagamento NUMERIC(15,2) NOT NULL,
-
-    cnpj_credenciadora CHAR(14),
-    bandeira_cartao CHAR(2),
-    codigo_autorizacao VARCHAR(60),
-
-    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-
-    CONSTRAINT fk_nfe_pagamento_nfe
-        FOREIGN KEY (nfe_id) REFERENCES nfe(id)
-        ON DELETE CASCADE
+//go:embed sql/*.sql
+var sqlFS embed.FS
+
+// migrationsLockID é a chave usada em pg_advisory_lock/pg_advisory_unlock
+// pra serializar execuções concorrentes do migrator. É um valor arbitrário,
+// mas fixo, só pra não colidir com locks de outras partes da aplicação.
+const migrationsLockID = 472_001
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration é uma migration versionada com seu SQL de ida e de volta.
+type Migration struct {
+	Version     int
+	Description string
+	UpSQL       string
+	DownSQL     string
+	Checksum    string // sha256 hex do UpSQL, usado pra detectar divergência
+}
+
+// StatusEntry descreve o estado de uma migration pra `migrations status`.
+type StatusEntry struct {
+	Version          int
+	Description      string
+	Applied          bool
+	AppliedAt        time.Time
+	Dirty            bool
+	ChecksumMismatch bool
+}
+
+// loadMigrations lê sql/*.sql embutido e monta a lista ordenada de
+// migrations, pareando os arquivos .up.sql e .down.sql de cada versão.
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(sqlFS, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo migrations embutidas: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		m := fileNameRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("arquivo de migration com nome inválido: %s (esperado NNNN_descricao.up|down.sql)", entry.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("versão inválida no arquivo %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(sqlFS, "sql/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("erro lendo %s: %w", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Description: m[2]}
+			byVersion[version] = mig
+		}
+
+		switch m[3] {
+		case "up":
+			mig.UpSQL = string(content)
+			sum := sha256.Sum256(content)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			mig.DownSQL = string(content)
+		}
+	}
+
+	migs := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.UpSQL == "" {
+			return nil, fmt.Errorf("migration %04d (%s) não tem arquivo .up.sql", mig.Version, mig.Description)
+		}
+		if mig.DownSQL == "" {
+			return nil, fmt.Errorf("migration %04d (%s) não tem arquivo .down.sql", mig.Version, mig.Description)
+		}
+		migs = append(migs, *mig)
+	}
+
+	sort.Slice(migs, func(i, j int) bool { return migs[i].Version < migs[j].Version })
+
+	return migs, nil
+}
+
+// ensureSchemaMigrationsTable cria a tabela de controle se ainda não existir.
+func ensureSchemaMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    description TEXT NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    applied_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    dirty BOOLEAN NOT NULL DEFAULT FALSE
 );
-`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_pagamentos_nfe ON nfe_pagamentos (nfe_id);`,
-		`CREATE INDEX IF NOT EXISTS idx_nfe_pagamentos_meio ON nfe_pagamentos (meio_pagamento);`,
+`)
+	if err != nil {
+		return fmt.Errorf("erro criando tabela schema_migrations: %w", err)
+	}
+	return nil
+}
+
+type appliedRow struct {
+	Version     int
+	Description string
+	Checksum    string
+	AppliedAt   time.Time
+	Dirty       bool
+}
+
+func appliedRows(db *sql.DB) (map[int]appliedRow, error) {
+	rows, err := db.Query(`SELECT version, description, checksum, applied_at, dirty FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("erro consultando schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedRow{}
+	for rows.Next() {
+		var r appliedRow
+		if err := rows.Scan(&r.Version, &r.Description, &r.Checksum, &r.AppliedAt, &r.Dirty); err != nil {
+			return nil, fmt.Errorf("erro lendo linha de schema_migrations: %w", err)
+		}
+		applied[r.Version] = r
+	}
+	return applied, rows.Err()
+}
+
+// withLock obtém um advisory lock de sessão do Postgres antes de rodar fn,
+// garantindo que execuções concorrentes do migrator não apliquem migrations
+// ao mesmo tempo. O lock é liberado ao final, com sucesso ou erro.
+func withLock(db *sql.DB, fn func() error) error {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("erro obtendo conexão para advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+		return fmt.Errorf("erro obtendo advisory lock de migrations: %w", err)
+	}
+	defer func() {
+		if _, uerr := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationsLockID); uerr != nil {
+			slog.Error("erro liberando advisory lock de migrations", "err", uerr)
+		}
+	}()
+
+	return fn()
+}
+
+// checkDirty devolve um erro descritivo se alguma migration ficou marcada
+// como dirty (uma execução anterior foi interrompida no meio).
+func checkDirty(applied map[int]appliedRow) error {
+	for _, r := range applied {
+		if r.Dirty {
+			return fmt.Errorf(
+				"banco em estado sujo na versão %04d (%s): uma execução anterior foi interrompida; "+
+					"resolva manualmente e rode 'force %d' para destravar",
+				r.Version, r.Description, r.Version,
+			)
+		}
+	}
+	return nil
+}
+
+// checkChecksums avisa (via log) quando o SQL embutido no binário diverge do
+// que foi de fato aplicado no banco — não bloqueia Up/Down, só sinaliza.
+func checkChecksums(migs []Migration, applied map[int]appliedRow) {
+	for _, m := range migs {
+		r, ok := applied[m.Version]
+		if !ok || r.Checksum == m.Checksum {
+			continue
+		}
+		slog.Warn("checksum da migration aplicada diverge do arquivo embutido no binário",
+			"version", m.Version,
+			"description", m.Description,
+		)
+	}
+}
+
+// execStatements executa cada statement de sqlText separadamente (dividido
+// por ";"), já que o driver pgx via database/sql não aceita múltiplos
+// comandos numa única chamada de Exec. Comentários de linha inteira (--) são
+// removidos antes do split: um bloco de comentário multi-linha antes do
+// primeiro statement faria o chunk inteiro (comentário + SQL) começar com
+// "--" e ser descartado por engano se filtrássemos statement a statement
+// depois do split.
+func execStatements(tx *sql.Tx, sqlText string) error {
+	for _, stmt := range strings.Split(stripLineComments(sqlText), ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("erro executando statement %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// stripLineComments remove linhas cujo conteúdo (ignorando espaços à
+// esquerda) começa com "--", preservando o SQL real de cada statement.
+func stripLineComments(sqlText string) string {
+	lines := strings.Split(sqlText, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	if _, err := db.Exec(`
+INSERT INTO schema_migrations (version, description, checksum, dirty)
+VALUES ($1, $2, $3, TRUE)
+ON CONFLICT (version) DO UPDATE SET dirty = TRUE, checksum = EXCLUDED.checksum
+`, m.Version, m.Description, m.Checksum); err != nil {
+		return fmt.Errorf("erro marcando migration %04d como dirty: %w", m.Version, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro iniciando transação da migration %04d: %w", m.Version, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = execStatements(tx, m.UpSQL); err != nil {
+		return fmt.Errorf("erro aplicando migration %04d (%s): %w", m.Version, m.Description, err)
+	}
+
+	if _, err = tx.Exec(`UPDATE schema_migrations SET dirty = FALSE, applied_at = CURRENT_TIMESTAMP(3) WHERE version = $1`, m.Version); err != nil {
+		return fmt.Errorf("erro finalizando bookkeeping da migration %04d: %w", m.Version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("erro commitando migration %04d: %w", m.Version, err)
+	}
+
+	slog.Info("migration aplicada", "version", m.Version, "description", m.Description)
+	return nil
+}
+
+func revertMigration(db *sql.DB, m Migration) error {
+	if _, err := db.Exec(`UPDATE schema_migrations SET dirty = TRUE WHERE version = $1`, m.Version); err != nil {
+		return fmt.Errorf("erro marcando migration %04d como dirty antes do rollback: %w", m.Version, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro iniciando transação de rollback da migration %04d: %w", m.Version, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = execStatements(tx, m.DownSQL); err != nil {
+		return fmt.Errorf("erro revertendo migration %04d (%s): %w", m.Version, m.Description, err)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+		return fmt.Errorf("erro removendo registro da migration %04d: %w", m.Version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("erro commitando rollback da migration %04d: %w", m.Version, err)
+	}
+
+	slog.Info("migration revertida", "version", m.Version, "description", m.Description)
+	return nil
+}
+
+// Up aplica as migrations pendentes, em ordem de versão. Se n > 0, aplica no
+// máximo n migrations; n == 0 aplica todas as pendentes.
+func Up(db *sql.DB, n int) error {
+	return withLock(db, func() error {
+		if err := ensureSchemaMigrationsTable(db); err != nil {
+			return err
+		}
+
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		applied, err := appliedRows(db)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		checkChecksums(migs, applied)
+
+		var pending []Migration
+		for _, m := range migs {
+			if _, ok := applied[m.Version]; !ok {
+				pending = append(pending, m)
+			}
+		}
+		if n > 0 && n < len(pending) {
+			pending = pending[:n]
+		}
+
+		if len(pending) == 0 {
+			slog.Info("nenhuma migration pendente")
+			return nil
+		}
+
+		for _, m := range pending {
+			if err := applyMigration(db, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverte as últimas n migrations aplicadas, da mais recente para a
+// mais antiga. n <= 0 é tratado como 1 (reverte só a última).
+func Down(db *sql.DB, n int) error {
+	return withLock(db, func() error {
+		if err := ensureSchemaMigrationsTable(db); err != nil {
+			return err
+		}
+
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		byVersion := map[int]Migration{}
+		for _, m := range migs {
+			byVersion[m.Version] = m
+		}
+
+		applied, err := appliedRows(db)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+
+		var versions []int
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		if n <= 0 {
+			n = 1
+		}
+		if n < len(versions) {
+			versions = versions[:n]
+		}
+
+		for _, v := range versions {
+			m, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("migration %04d está aplicada no banco mas não existe mais embutida no binário", v)
+			}
+			if err := revertMigration(db, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Redo reverte e reaplica a última migration aplicada.
+func Redo(db *sql.DB) error {
+	return withLock(db, func() error {
+		if err := ensureSchemaMigrationsTable(db); err != nil {
+			return err
+		}
+
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		byVersion := map[int]Migration{}
+		for _, m := range migs {
+			byVersion[m.Version] = m
+		}
+
+		applied, err := appliedRows(db)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return fmt.Errorf("nenhuma migration aplicada para refazer")
+		}
+
+		last := -1
+		for v := range applied {
+			if v > last {
+				last = v
+			}
+		}
+
+		m, ok := byVersion[last]
+		if !ok {
+			return fmt.Errorf("migration %04d está aplicada no banco mas não existe mais embutida no binário", last)
+		}
+
+		if err := revertMigration(db, m); err != nil {
+			return err
+		}
+		return applyMigration(db, m)
+	})
+}
+
+// Goto move o banco para exatamente a versão alvo, aplicando ou revertendo
+// migrations conforme necessário.
+func Goto(db *sql.DB, target int) error {
+	return withLock(db, func() error {
+		if err := ensureSchemaMigrationsTable(db); err != nil {
+			return err
+		}
+
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, m := range migs {
+			if m.Version == target {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("versão %04d não existe entre as migrations embutidas", target)
+		}
+
+		applied, err := appliedRows(db)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+
+		for _, m := range migs {
+			_, isApplied := applied[m.Version]
+			switch {
+			case m.Version <= target && !isApplied:
+				if err := applyMigration(db, m); err != nil {
+					return err
+				}
+			case m.Version > target && isApplied:
+				if err := revertMigration(db, m); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Force marca a versão informada como aplicada e não-dirty sem rodar o SQL
+// dela, usado pra destravar o banco depois que um operador resolveu uma
+// migration dirty manualmente.
+func Force(db *sql.DB, version int) error {
+	return withLock(db, func() error {
+		if err := ensureSchemaMigrationsTable(db); err != nil {
+			return err
+		}
+
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		var target *Migration
+		for i := range migs {
+			if migs[i].Version == version {
+				target = &migs[i]
+				break
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("versão %04d não existe entre as migrations embutidas", version)
+		}
+
+		_, err = db.Exec(`
+INSERT INTO schema_migrations (version, description, checksum, dirty, applied_at)
+VALUES ($1, $2, $3, FALSE, CURRENT_TIMESTAMP(3))
+ON CONFLICT (version) DO UPDATE SET dirty = FALSE, checksum = EXCLUDED.checksum
+`, target.Version, target.Description, target.Checksum)
+		if err != nil {
+			return fmt.Errorf("erro forçando versão %04d: %w", version, err)
+		}
+
+		slog.Warn("versão forçada manualmente, sem executar SQL", "version", version)
+		return nil
+	})
+}
+
+// Status devolve o estado de cada migration conhecida (aplicada ou
+// pendente), incluindo dirty flag e divergência de checksum, pra diagnóstico
+// sem precisar dropar o banco.
+func Status(db *sql.DB) ([]StatusEntry, error) {
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	migs, err := loadMigrations()
+	if err != nil {
+		return nil, err
 	}
 
-	for i, stmt := range stmts {
-		if _, err := db.Exec(stmt); err != nil {
-			return fmt.Errorf("erro executando migration %d: %w", i+1, err)
+	applied, err := appliedRows(db)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StatusEntry, 0, len(migs))
+	for _, m := range migs {
+		e := StatusEntry{Version: m.Version, Description: m.Description}
+		if r, ok := applied[m.Version]; ok {
+			e.Applied = true
+			e.AppliedAt = r.AppliedAt
+			e.Dirty = r.Dirty
+			e.ChecksumMismatch = r.Checksum != m.Checksum
 		}
+		entries = append(entries, e)
 	}
 
+	return entries, nil
+}
+
+// MigrationStatus é um apelido de StatusEntry para scripts/operadores que
+// esperam a nomenclatura mais convencional de migrators (golang-migrate,
+// goose). Mesmo formato, sem duplicar a struct.
+type MigrationStatus = StatusEntry
+
+// MigrateTo move o banco para exatamente a versão alvo. Apelido de Goto com
+// o nome que operadores acostumados a outros migrators (golang-migrate,
+// Storj) esperam encontrar.
+func MigrateTo(db *sql.DB, target int) error {
+	return Goto(db, target)
+}
+
+// Rollback reverte as últimas steps migrations aplicadas. Apelido de Down
+// com o nome convencional de migrator, pra quem está revertendo um deploy
+// ruim sem precisar lembrar que aqui o nome histórico é Down.
+func Rollback(db *sql.DB, steps int) error {
+	return Down(db, steps)
+}
+
+// RunSharded aplica todas as migrations pendentes (Up com n=0) em cada shard
+// de dbs, na ordem informada, parando no primeiro erro. Pensado pro topologia
+// de sharding por emitente_cnpj de internal/sharding: todo shard roda o
+// mesmo schema (nfe, nfe_item, etc), então não existe um Up "por shard"
+// diferente, só N execuções do runner de sempre.
+//
+// A DB de metadados compartilhada (nfe_shard_map, nfe_shard_events, ver
+// 0005_shard_map) não faz parte de dbs — ela roda o mesmo Up/RunSharded
+// separadamente, contra sua própria conexão, porque pode ser um banco
+// dedicado ou coincidir com um dos shards; RunSharded não assume nenhum dos
+// dois.
+func RunSharded(dbs []*sql.DB) error {
+	for i, db := range dbs {
+		if err := Up(db, 0); err != nil {
+			return fmt.Errorf("erro aplicando migrations no shard %d: %w", i, err)
+		}
+	}
 	return nil
 }
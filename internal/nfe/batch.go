@@ -0,0 +1,296 @@
+package nfe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	xsdvalidate "github.com/form3tech-oss/go-xsd-validate"
+)
+
+// BatchOptions configura ParseDir.
+type BatchOptions struct {
+	// Workers é o número de goroutines que parseiam arquivos em paralelo.
+	// <= 0 usa runtime.NumCPU().
+	Workers int
+	// Recursive faz ParseDir descer em subdiretórios de root. Quando false,
+	// só os arquivos diretamente em root são considerados.
+	Recursive bool
+	// Glob filtra pelo nome do arquivo (não o path completo), ex: "*.xml".
+	// Vazio equivale a "*.xml".
+	Glob string
+	// SkipXSD desliga a validação XSD pra este lote mesmo se
+	// NFE_XSD_ENABLED estiver setado no ambiente.
+	SkipXSD bool
+	// MaxFileSize descarta (como BatchResult.Err) arquivos maiores que esse
+	// limite, em bytes. <= 0 não aplica limite.
+	MaxFileSize int64
+	// Progress, se não nil, é chamado a cada arquivo concluído com o total
+	// de arquivos já processados e o total encontrado na varredura inicial.
+	Progress func(done, total int)
+}
+
+// BatchResult é o que ParseDir envia no canal de retorno pra cada arquivo
+// visitado: ou NFe populado (sucesso) ou Err (falha de leitura/parse/XSD),
+// nunca os dois.
+type BatchResult struct {
+	Path string
+	NFe  *ParsedNFe
+	Err  error
+}
+
+// ParseDir varre root (recursivamente se opts.Recursive) atrás de arquivos
+// que casam com opts.Glob, e faz o parse de cada um em paralelo com
+// opts.Workers goroutines, streamando um BatchResult por arquivo no canal
+// devolvido — nunca acumula os ParsedNFe do lote inteiro em memória, só o
+// que está em voo nos workers. Pensado pra lotes de dezenas de milhares de
+// XML que firmas de contabilidade entregam de uma vez.
+//
+// O canal é fechado quando todo arquivo encontrado na varredura inicial foi
+// processado ou ctx é cancelado. Se a varredura em si (ou a montagem do
+// validador XSD) falha, ParseDir devolve canal nil e o erro, sem iniciar
+// nenhum worker.
+// Cancelar ctx interrompe workers ociosos entre arquivos; um parse já em
+// andamento roda até o fim (ParseBytes não é interrompível no meio).
+func ParseDir(ctx context.Context, root string, opts BatchOptions) (<-chan BatchResult, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	glob := opts.Glob
+	if glob == "" {
+		glob = "*.xml"
+	}
+
+	paths, err := collectBatchPaths(root, opts.Recursive, glob)
+	if err != nil {
+		return nil, fmt.Errorf("erro varrendo %s: %w", root, err)
+	}
+
+	shared, err := newSharedXSDValidatorFromEnv(opts.SkipXSD)
+	if err != nil {
+		return nil, err
+	}
+
+	rawOut := make(chan BatchResult)
+	jobs := make(chan string)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			runBatchWorker(ctx, jobs, rawOut, opts, shared)
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, p := range paths {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- p:
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		if shared != nil {
+			shared.Close()
+		}
+		close(rawOut)
+	}()
+
+	if opts.Progress == nil {
+		return rawOut, nil
+	}
+	total := len(paths)
+	opts.Progress(0, total)
+	return wrapWithProgress(rawOut, total, opts.Progress), nil
+}
+
+// runBatchWorker consome jobs até o canal fechar ou ctx cancelar, parseando
+// cada arquivo e publicando o resultado em out.
+func runBatchWorker(ctx context.Context, jobs <-chan string, out chan<- BatchResult, opts BatchOptions, shared *sharedXSDValidator) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path, ok := <-jobs:
+			if !ok {
+				return
+			}
+			result := parseBatchFile(path, opts, shared)
+			select {
+			case out <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func parseBatchFile(path string, opts BatchOptions, shared *sharedXSDValidator) BatchResult {
+	if opts.MaxFileSize > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return BatchResult{Path: path, Err: fmt.Errorf("erro lendo %s: %w", path, err)}
+		}
+		if info.Size() > opts.MaxFileSize {
+			return BatchResult{Path: path, Err: fmt.Errorf("arquivo %s (%d bytes) excede MaxFileSize (%d bytes)", path, info.Size(), opts.MaxFileSize)}
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BatchResult{Path: path, Err: fmt.Errorf("erro lendo %s: %w", path, err)}
+	}
+
+	parsed, err := parseBytes(data, path, shared)
+	if err != nil {
+		return BatchResult{Path: path, Err: err}
+	}
+	return BatchResult{Path: path, NFe: parsed}
+}
+
+// collectBatchPaths resolve a lista de arquivos que ParseDir vai processar.
+// É uma única varredura em memória (só paths, strings leves) — o que
+// ParseDir deliberadamente NÃO acumula é o conteúdo/parse dos arquivos.
+func collectBatchPaths(root string, recursive bool, glob string) ([]string, error) {
+	var paths []string
+
+	if !recursive {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if ok, _ := filepath.Match(glob, e.Name()); ok {
+				paths = append(paths, filepath.Join(root, e.Name()))
+			}
+		}
+		return paths, nil
+	}
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if ok, _ := filepath.Match(glob, d.Name()); ok {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+func wrapWithProgress(in <-chan BatchResult, total int, progress func(done, total int)) <-chan BatchResult {
+	out := make(chan BatchResult)
+	go func() {
+		defer close(out)
+		done := 0
+		for r := range in {
+			done++
+			progress(done, total)
+			out <- r
+		}
+	}()
+	return out
+}
+
+// ============================================================================
+// XsdHandler compartilhado entre workers de ParseDir
+//
+// validateXMLWithXSD (usada por ParseBytes fora de um lote) faz Init +
+// NewXsdHandlerUrl + Cleanup a cada chamada — aceitável pra um XML solto,
+// mas custo proibitivo multiplicado por dezenas de milhares de arquivos.
+// sharedXSDValidator monta esse handler uma vez por ParseDir e serializa o
+// acesso com um mutex: o go-xsd-validate envolve um xmlSchemaValidCtxt do
+// libxml2 por trás, que não é thread-safe pra reuso concorrente — então
+// workers ganham paralelismo na leitura/parse struct-based, mas a validação
+// XSD em si continua serializada.
+// ============================================================================
+
+type sharedXSDValidator struct {
+	mu      sync.Mutex
+	handler *xsdvalidate.XsdHandler
+}
+
+// newSharedXSDValidatorFromEnv monta um sharedXSDValidator lendo
+// NFE_XSD_ENABLED/NFE_XSD_DIR/NFE_XSD_MAIN do ambiente, mesma fonte que
+// ParseBytes usa fora de lote. Com skip (opts.SkipXSD), devolve um
+// sharedXSDValidator sem handler, que aceita qualquer XML: como parseBytes
+// com shared != nil não consulta NFE_XSD_ENABLED, o lote inteiro fica sem
+// XSD. Devolve (nil, nil) quando XSD está desabilitado pelo env — nesse caso
+// parseBytes consulta o env, vê que está desligado e pula a validação.
+func newSharedXSDValidatorFromEnv(skip bool) (*sharedXSDValidator, error) {
+	if skip {
+		return &sharedXSDValidator{}, nil
+	}
+	xsdEnabled := strings.ToLower(os.Getenv("NFE_XSD_ENABLED"))
+	if xsdEnabled != "true" && xsdEnabled != "1" && xsdEnabled != "yes" {
+		return nil, nil
+	}
+
+	xsdDir := os.Getenv("NFE_XSD_DIR")
+	xsdMain := os.Getenv("NFE_XSD_MAIN")
+	if xsdDir == "" {
+		return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_DIR não foi definido")
+	}
+	if xsdMain == "" {
+		return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_MAIN não foi definido (ex: procNFe_v4.00.xsd)")
+	}
+	xsdPath, err := resolveXSDPath(xsdDir, xsdMain)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(xsdPath); err != nil {
+		return nil, fmt.Errorf("XSD não encontrado em %s: %w", xsdPath, err)
+	}
+
+	if err := xsdvalidate.Init(); err != nil {
+		return nil, fmt.Errorf("erro inicializando validador XSD: %w", err)
+	}
+	handler, err := xsdvalidate.NewXsdHandlerUrl(xsdPath, xsdvalidate.ParsErrDefault)
+	if err != nil {
+		xsdvalidate.Cleanup()
+		return nil, fmt.Errorf("erro carregando XSD %s: %w", xsdPath, err)
+	}
+
+	return &sharedXSDValidator{handler: handler}, nil
+}
+
+func (v *sharedXSDValidator) ValidateMem(data []byte) error {
+	if v.handler == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.handler.ValidateMem(data, xsdvalidate.ValidErrDefault); err != nil {
+		return fmt.Errorf("XML inválido segundo XSD: %w", err)
+	}
+	return nil
+}
+
+func (v *sharedXSDValidator) Close() {
+	if v.handler == nil {
+		return
+	}
+	v.handler.Free()
+	xsdvalidate.Cleanup()
+}
@@ -0,0 +1,177 @@
+package nfe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// ============================================================================
+// Eventos de NF-e (procEventoNFe): Cancelamento (110111), Carta de Correção
+// (110110) e Manifestação do Destinatário (210200/210210/210220/210240).
+// Envelope irmão de nfeProc/NFe — o resto do pacote (buildParsedFrom etc)
+// não toca nesses tipos.
+// ============================================================================
+
+type procEventoNFe struct {
+	XMLName   xml.Name  `xml:"procEventoNFe"`
+	Evento    evento    `xml:"evento"`
+	RetEvento retEvento `xml:"retEventoNFe"`
+}
+
+type evento struct {
+	InfEvento infEvento `xml:"infEvento"`
+}
+
+type infEvento struct {
+	ID         string    `xml:"Id,attr"`
+	CNPJ       string    `xml:"CNPJ"`
+	CPF        string    `xml:"CPF"`
+	ChNFe      string    `xml:"chNFe"`
+	DhEvento   string    `xml:"dhEvento"`
+	TpEvento   string    `xml:"tpEvento"`
+	NSeqEvento string    `xml:"nSeqEvento"`
+	DetEvento  detEvento `xml:"detEvento"`
+}
+
+// detEvento varia por tpEvento: xJust é o campo da Cancelamento, xCorrecao
+// da Carta de Correção, xCondUso/xJust da Manifestação do Destinatário.
+// Modelar os três num struct só (em vez de um por tpEvento) é o mesmo
+// atalho que buildItemFromDet já usa pros vários grupos ICMSxx — os campos
+// que não se aplicam simplesmente vêm vazios.
+type detEvento struct {
+	DescEvento string `xml:"descEvento"`
+	XJust      string `xml:"xJust"`
+	XCorrecao  string `xml:"xCorrecao"`
+	XCondUso   string `xml:"xCondUso"`
+}
+
+type retEvento struct {
+	RetInfEvento struct {
+		ChNFe string `xml:"chNFe"`
+		NProt string `xml:"nProt"`
+		CStat string `xml:"cStat"`
+	} `xml:"infEvento"`
+}
+
+// ParsedEvento é o objeto de saída de ParseEventFile/ParseEventBytes,
+// análogo a ParsedNFe mas pro envelope de evento.
+type ParsedEvento struct {
+	ChaveAcesso     string
+	TipoEvento      int
+	SequenciaEvento int
+	DataEvento      string // YYYY-MM-DD
+	ProtocoloEvento string
+	CodigoStatus    int
+	Justificativa   string // xJust (Cancelamento) ou xCondUso (Manifestação)
+	XCorrecao       string // xCorrecao (Carta de Correção)
+	CNPJAutor       string
+	HashIntegridade string
+	XMLRaw          []byte
+}
+
+// ParsedDocument é o sum type devolvido por ParseAnyFile/ParseAnyBytes:
+// exatamente um dos dois campos vem preenchido, nunca os dois, nunca
+// nenhum — é assim que um chamador varrendo uma pasta mista de NFe e
+// eventos (ex: um ParseDir futuro sobre EventsDir) decide o que fazer com
+// cada arquivo sem precisar adivinhar o tipo antes de parsear.
+type ParsedDocument struct {
+	NFe    *ParsedNFe
+	Evento *ParsedEvento
+}
+
+// ParseEventFile lê e parseia um XML de evento de NF-e (procEventoNFe) do
+// disco.
+func ParseEventFile(path string) (*ParsedEvento, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo XML de evento %s: %w", path, err)
+	}
+	return ParseEventBytes(data, path)
+}
+
+// ParseEventBytes faz o parse de um XML de evento de NF-e já em memória.
+// `label` só compõe mensagens de erro, igual ParseBytes.
+func ParseEventBytes(data []byte, label string) (*ParsedEvento, error) {
+	hash := sha256.Sum256(data)
+	hashHex := hex.EncodeToString(hash[:])
+
+	var proc procEventoNFe
+	if err := xml.Unmarshal(data, &proc); err != nil || proc.Evento.InfEvento.ChNFe == "" {
+		return nil, fmt.Errorf("XML não reconhecido como procEventoNFe (origem: %s)", label)
+	}
+
+	inf := proc.Evento.InfEvento
+	justificativa := inf.DetEvento.XJust
+	if justificativa == "" {
+		justificativa = inf.DetEvento.XCondUso
+	}
+
+	return &ParsedEvento{
+		ChaveAcesso:     inf.ChNFe,
+		TipoEvento:      parseInt(inf.TpEvento),
+		SequenciaEvento: parseInt(inf.NSeqEvento),
+		DataEvento:      normalizeDateYMD(inf.DhEvento),
+		ProtocoloEvento: proc.RetEvento.RetInfEvento.NProt,
+		CodigoStatus:    parseInt(proc.RetEvento.RetInfEvento.CStat),
+		Justificativa:   justificativa,
+		XCorrecao:       inf.DetEvento.XCorrecao,
+		CNPJAutor:       inf.CNPJ,
+		HashIntegridade: hashHex,
+		XMLRaw:          data,
+	}, nil
+}
+
+// ParseAnyFile lê path do disco e despacha pra ParseBytes ou
+// ParseEventBytes conforme o elemento raiz do XML, pra quem varre uma pasta
+// que mistura NFe autorizadas e eventos (cancelamento, CC-e, manifestação)
+// sem saber de antemão qual é qual.
+func ParseAnyFile(path string) (*ParsedDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo XML %s: %w", path, err)
+	}
+	return ParseAnyBytes(data, path)
+}
+
+// ParseAnyBytes é a versão em memória de ParseAnyFile.
+func ParseAnyBytes(data []byte, label string) (*ParsedDocument, error) {
+	if isEventoEnvelope(data) {
+		evt, err := ParseEventBytes(data, label)
+		if err != nil {
+			return nil, err
+		}
+		return &ParsedDocument{Evento: evt}, nil
+	}
+
+	parsed, err := ParseBytes(data, label)
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedDocument{NFe: parsed}, nil
+}
+
+// isEventoEnvelope espia só o nome do elemento raiz do XML (sem decodificar
+// o documento inteiro) pra decidir entre o envelope de NFe (nfeProc/NFe) e
+// o de evento (procEventoNFe/evento), antes de escolher qual Unmarshal
+// completo rodar.
+func isEventoEnvelope(data []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "procEventoNFe", "evento", "envEvento":
+				return true
+			default:
+				return false
+			}
+		}
+	}
+}
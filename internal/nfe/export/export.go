@@ -0,0 +1,337 @@
+// Package export serializa []*nfe.ParsedNFe pros formatos que o data lake
+// consome: NDJSON (um arquivo, leitura direta por DuckDB/Athena via
+// read_json_auto) e Parquet (três tabelas ligadas por chave_acesso, pro
+// caso de volume onde NDJSON sai caro de escanear).
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"nfe-drop/internal/nfe"
+)
+
+// WriteJSONL escreve nfes como NDJSON (um nfe.ParsedNFe por linha, no
+// schema estável de (*nfe.ParsedNFe).MarshalJSON) em w. nil em nfes é
+// ignorado silenciosamente — mesmo padrão de "pular o que não existe" que
+// BatchResult já usa pra erros de lote.
+func WriteJSONL(w io.Writer, nfes ...*nfe.ParsedNFe) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i, p := range nfes {
+		if p == nil {
+			continue
+		}
+		if err := enc.Encode(p); err != nil {
+			return fmt.Errorf("erro serializando NF-e #%d (chave %s) em NDJSON: %w", i, p.ChaveAcesso, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// ============================================================================
+// Parquet
+//
+// Um arquivo Parquet tem um schema só; "três row groups logicamente
+// ligados" (header, itens, pagamentos+duplicatas) na prática são três
+// tabelas — cada uma com seu próprio schema — e não cabem num io.Writer só
+// sem perder a estrutura (não dá pra concatenar três Parquets distintos num
+// stream e ler como um arquivo válido). WriteParquet, com a assinatura que
+// foi pedida, escreve a tabela de cabeçalho (um registro por NF-e) em w;
+// WriteParquetTables é quem escreve as três tabelas de fato, uma por
+// io.Writer, todas com chave_acesso como chave de junção — é essa função
+// que deve ser usada pra alimentar o data lake particionado por
+// emissao_date/emitente_cnpj, com cada tabela virando um arquivo num layout
+// Hive-style (ex: emissao_date=2026-07-27/emitente_cnpj=.../header.parquet).
+//
+// Nota de honestidade: este pacote depende de github.com/xitongsys/
+// parquet-go (+ parquet-go-source/writerfile pra escrever num io.Writer
+// puro em vez de um caminho de arquivo). Este ambiente não tem go.mod nem
+// acesso à rede pra buscar o módulo e compilar de fato — o código abaixo
+// segue a API documentada da lib o mais fielmente possível, mas só vai ser
+// validada por `go build` quando o projeto ganhar seu go.mod de verdade.
+// ============================================================================
+
+type headerRow struct {
+	ChaveAcesso      string  `parquet:"name=chave_acesso, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HashIntegridade  string  `parquet:"name=hash_integridade, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Modelo           int32   `parquet:"name=modelo, type=INT32"`
+	Serie            int32   `parquet:"name=serie, type=INT32"`
+	Numero           int32   `parquet:"name=numero, type=INT32"`
+	EmissaoDate      string  `parquet:"name=emissao_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TipoOperacao     int32   `parquet:"name=tipo_operacao, type=INT32"`
+	TipoAmbiente     int32   `parquet:"name=tipo_ambiente, type=INT32"`
+	NaturezaOperacao string  `parquet:"name=natureza_operacao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ProtocoloAut     string  `parquet:"name=protocolo_aut, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DataAutorizacao  string  `parquet:"name=data_autorizacao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CodigoStatus     int32   `parquet:"name=codigo_status, type=INT32"`
+	EmitenteCNPJ     string  `parquet:"name=emitente_cnpj, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EmitenteRazao    string  `parquet:"name=emitente_razao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DestCNPJCPF      string  `parquet:"name=dest_cnpj_cpf, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DestRazao        string  `parquet:"name=dest_razao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ValorTotalNota   float64 `parquet:"name=valor_total_nota, type=DOUBLE"`
+	ValorProdutos    float64 `parquet:"name=valor_produtos, type=DOUBLE"`
+	ValorDesconto    float64 `parquet:"name=valor_desconto, type=DOUBLE"`
+	ValorICMS        float64 `parquet:"name=valor_icms, type=DOUBLE"`
+	ValorIPI         float64 `parquet:"name=valor_ipi, type=DOUBLE"`
+	ValorPIS         float64 `parquet:"name=valor_pis, type=DOUBLE"`
+	ValorCOFINS      float64 `parquet:"name=valor_cofins, type=DOUBLE"`
+	ValorII          float64 `parquet:"name=valor_ii, type=DOUBLE"`
+	ValorFrete       float64 `parquet:"name=valor_frete, type=DOUBLE"`
+	ValorSeguro      float64 `parquet:"name=valor_seguro, type=DOUBLE"`
+	ModalidadeFrete  int32   `parquet:"name=modalidade_frete, type=INT32"`
+	SignatureValid   bool    `parquet:"name=signature_valid, type=BOOLEAN"`
+}
+
+type itemRow struct {
+	ChaveAcesso       string  `parquet:"name=chave_acesso, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NItem             int32   `parquet:"name=n_item, type=INT32"`
+	Codigo            string  `parquet:"name=codigo, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CodigoEAN         string  `parquet:"name=codigo_ean, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Descricao         string  `parquet:"name=descricao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NCM               string  `parquet:"name=ncm, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CFOP              string  `parquet:"name=cfop, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Unidade           string  `parquet:"name=unidade, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Quantidade        float64 `parquet:"name=quantidade, type=DOUBLE"`
+	ValorUnitario     float64 `parquet:"name=valor_unitario, type=DOUBLE"`
+	ValorTotalBruto   float64 `parquet:"name=valor_total_bruto, type=DOUBLE"`
+	ValorFrete        float64 `parquet:"name=valor_frete, type=DOUBLE"`
+	ValorSeguro       float64 `parquet:"name=valor_seguro, type=DOUBLE"`
+	ValorDesconto     float64 `parquet:"name=valor_desconto, type=DOUBLE"`
+	ValorOutros       float64 `parquet:"name=valor_outros, type=DOUBLE"`
+	IndTotal          int32   `parquet:"name=ind_total, type=INT32"`
+	BaseCalculoICMS   float64 `parquet:"name=base_calculo_icms, type=DOUBLE"`
+	ValorICMS         float64 `parquet:"name=valor_icms, type=DOUBLE"`
+	BaseCalculoICMSST float64 `parquet:"name=base_calculo_icmsst, type=DOUBLE"`
+	ValorICMSST       float64 `parquet:"name=valor_icmsst, type=DOUBLE"`
+	ValorIPI          float64 `parquet:"name=valor_ipi, type=DOUBLE"`
+	ValorPIS          float64 `parquet:"name=valor_pis, type=DOUBLE"`
+	ValorCOFINS       float64 `parquet:"name=valor_cofins, type=DOUBLE"`
+}
+
+// financeiroRow cobre tanto pagamentos quanto duplicatas num schema só,
+// discriminado por RegistroTipo — os campos que não se aplicam ao tipo da
+// linha vêm vazios/zero, o mesmo atalho que detEvento e buildItemFromDet já
+// usam pro resto do pacote pra variantes de um mesmo grupo.
+type financeiroRow struct {
+	ChaveAcesso        string  `parquet:"name=chave_acesso, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RegistroTipo       string  `parquet:"name=registro_tipo, type=BYTE_ARRAY, convertedtype=UTF8"`   // "pagamento" ou "duplicata"
+	Numero             string  `parquet:"name=numero, type=BYTE_ARRAY, convertedtype=UTF8"`          // nDup, só em duplicata
+	DataVencimento     string  `parquet:"name=data_vencimento, type=BYTE_ARRAY, convertedtype=UTF8"` // só em duplicata
+	IndicadorPagamento int32   `parquet:"name=indicador_pagamento, type=INT32"`                      // só em pagamento
+	MeioPagamento      string  `parquet:"name=meio_pagamento, type=BYTE_ARRAY, convertedtype=UTF8"`  // só em pagamento
+	CNPJCredenciadora  string  `parquet:"name=cnpj_credenciadora, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BandeiraCartao     string  `parquet:"name=bandeira_cartao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CodigoAutorizacao  string  `parquet:"name=codigo_autorizacao, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Valor              float64 `parquet:"name=valor, type=DOUBLE"`
+}
+
+// WriteParquet escreve a tabela de cabeçalho (um registro por NF-e) em
+// formato Parquet em w. Pra itens e pagamentos+duplicatas, que não cabem no
+// mesmo arquivo/schema, use WriteParquetTables.
+func WriteParquet(w io.Writer, nfes ...*nfe.ParsedNFe) error {
+	rows := make([]headerRow, 0, len(nfes))
+	for _, p := range nfes {
+		if p == nil {
+			continue
+		}
+		rows = append(rows, toHeaderRow(p))
+	}
+	return writeParquetRows(w, new(headerRow), len(rows), func(pw *writer.ParquetWriter) error {
+		for i := range rows {
+			if err := pw.Write(rows[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteParquetTables escreve as três tabelas normalizadas (header, itens,
+// financeiro) em três destinos independentes, todas ligadas por
+// chave_acesso. O chamador decide o particionamento físico (ex: escrever
+// cada tabela num caminho tipo
+// s3://bucket/nfe/emissao_date=2026-07-27/emitente_cnpj=.../header.parquet)
+// — este pacote só sabe serializar, não sabe de layout de bucket.
+func WriteParquetTables(headerW, itensW, financeiroW io.Writer, nfes ...*nfe.ParsedNFe) error {
+	var headers []headerRow
+	var itens []itemRow
+	var financeiro []financeiroRow
+
+	for _, p := range nfes {
+		if p == nil {
+			continue
+		}
+		headers = append(headers, toHeaderRow(p))
+		itens = append(itens, toItemRows(p)...)
+		financeiro = append(financeiro, toFinanceiroRows(p)...)
+	}
+
+	if err := writeParquetRows(headerW, new(headerRow), len(headers), func(pw *writer.ParquetWriter) error {
+		for i := range headers {
+			if err := pw.Write(headers[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("erro escrevendo tabela header: %w", err)
+	}
+
+	if err := writeParquetRows(itensW, new(itemRow), len(itens), func(pw *writer.ParquetWriter) error {
+		for i := range itens {
+			if err := pw.Write(itens[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("erro escrevendo tabela itens: %w", err)
+	}
+
+	if err := writeParquetRows(financeiroW, new(financeiroRow), len(financeiro), func(pw *writer.ParquetWriter) error {
+		for i := range financeiro {
+			if err := pw.Write(financeiro[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("erro escrevendo tabela financeiro (pagamentos+duplicatas): %w", err)
+	}
+
+	return nil
+}
+
+// writeParquetRows monta um ParquetWriter sobre w pro schema de obj, roda
+// write (que deve chamar pw.Write pra cada linha) e fecha tudo na ordem
+// certa (WriteStop antes do Close, senão o footer não sai).
+func writeParquetRows(w io.Writer, obj interface{}, numRows int, write func(pw *writer.ParquetWriter) error) error {
+	pfile := writerfile.NewWriterFile(w)
+
+	np := int64(4)
+	if numRows > 0 && int64(numRows) < np {
+		np = int64(numRows)
+	}
+	if np < 1 {
+		np = 1
+	}
+
+	pw, err := writer.NewParquetWriter(pfile, obj, np)
+	if err != nil {
+		return fmt.Errorf("erro criando parquet writer: %w", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	if err := write(pw); err != nil {
+		return fmt.Errorf("erro escrevendo linhas parquet: %w", err)
+	}
+	if err := pw.WriteStop(); err != nil {
+		return fmt.Errorf("erro finalizando parquet: %w", err)
+	}
+	return pfile.Close()
+}
+
+func toHeaderRow(p *nfe.ParsedNFe) headerRow {
+	return headerRow{
+		ChaveAcesso:      p.ChaveAcesso,
+		HashIntegridade:  p.HashIntegridade,
+		Modelo:           int32(p.Modelo),
+		Serie:            int32(p.Serie),
+		Numero:           int32(p.Numero),
+		EmissaoDate:      p.EmissaoDate,
+		TipoOperacao:     int32(p.TipoOperacao),
+		TipoAmbiente:     int32(p.TipoAmbiente),
+		NaturezaOperacao: p.NaturezaOperacao,
+		ProtocoloAut:     p.ProtocoloAut,
+		DataAutorizacao:  p.DataAutorizacao,
+		CodigoStatus:     int32(p.CodigoStatus),
+		EmitenteCNPJ:     p.EmitenteCNPJ,
+		EmitenteRazao:    p.EmitenteRazao,
+		DestCNPJCPF:      p.DestCNPJCPF,
+		DestRazao:        p.DestRazao,
+		ValorTotalNota:   p.ValorTotalNota,
+		ValorProdutos:    p.ValorProdutos,
+		ValorDesconto:    p.ValorDesconto,
+		ValorICMS:        p.ValorICMS,
+		ValorIPI:         p.ValorIPI,
+		ValorPIS:         p.ValorPIS,
+		ValorCOFINS:      p.ValorCOFINS,
+		ValorII:          p.ValorII,
+		ValorFrete:       p.ValorFrete,
+		ValorSeguro:      p.ValorSeguro,
+		ModalidadeFrete:  int32(p.ModalidadeFrete),
+		SignatureValid:   p.SignatureValid,
+	}
+}
+
+func toItemRows(p *nfe.ParsedNFe) []itemRow {
+	rows := make([]itemRow, 0, len(p.Itens))
+	for _, it := range p.Itens {
+		rows = append(rows, itemRow{
+			ChaveAcesso:       p.ChaveAcesso,
+			NItem:             int32(it.NItem),
+			Codigo:            it.Codigo,
+			CodigoEAN:         it.CodigoEAN,
+			Descricao:         it.Descricao,
+			NCM:               it.NCM,
+			CFOP:              it.CFOP,
+			Unidade:           it.Unidade,
+			Quantidade:        it.Quantidade,
+			ValorUnitario:     it.ValorUnitario,
+			ValorTotalBruto:   it.ValorTotalBruto,
+			ValorFrete:        it.ValorFrete,
+			ValorSeguro:       it.ValorSeguro,
+			ValorDesconto:     it.ValorDesconto,
+			ValorOutros:       it.ValorOutros,
+			IndTotal:          int32(it.IndTotal),
+			BaseCalculoICMS:   it.BaseCalculoICMS,
+			ValorICMS:         it.ValorICMS,
+			BaseCalculoICMSST: it.BaseCalculoICMSST,
+			ValorICMSST:       it.ValorICMSST,
+			ValorIPI:          it.ValorIPI,
+			ValorPIS:          it.ValorPIS,
+			ValorCOFINS:       it.ValorCOFINS,
+		})
+	}
+	return rows
+}
+
+func toFinanceiroRows(p *nfe.ParsedNFe) []financeiroRow {
+	rows := make([]financeiroRow, 0, len(p.Pagamentos)+len(p.Duplicatas))
+
+	for _, pg := range p.Pagamentos {
+		var ind int32
+		if pg.IndicadorPagamento != nil {
+			ind = int32(*pg.IndicadorPagamento)
+		}
+		rows = append(rows, financeiroRow{
+			ChaveAcesso:        p.ChaveAcesso,
+			RegistroTipo:       "pagamento",
+			IndicadorPagamento: ind,
+			MeioPagamento:      pg.MeioPagamento,
+			CNPJCredenciadora:  pg.CNPJCredenciadora,
+			BandeiraCartao:     pg.BandeiraCartao,
+			CodigoAutorizacao:  pg.CodigoAutorizacao,
+			Valor:              pg.Valor,
+		})
+	}
+
+	for _, du := range p.Duplicatas {
+		rows = append(rows, financeiroRow{
+			ChaveAcesso:    p.ChaveAcesso,
+			RegistroTipo:   "duplicata",
+			Numero:         du.Numero,
+			DataVencimento: du.DataVencimento,
+			Valor:          du.Valor,
+		})
+	}
+
+	return rows
+}
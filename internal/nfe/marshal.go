@@ -0,0 +1,184 @@
+package nfe
+
+import "encoding/json"
+
+// ============================================================================
+// Serialização JSON estável
+//
+// O struct ParsedNFe é interno e muda conforme o parser ganha campos (ver
+// SignatureValid, Violations, etc. adicionados nos chunks anteriores);
+// quem consome fora de Go (export pro data lake, um endpoint HTTP futuro)
+// não deveria quebrar a cada campo novo. MarshalJSON fixa um schema
+// snake_case próprio (jsonNFe) como o contrato público, na mesma lógica que
+// as migrações versionadas já usam pro schema do banco: o shape externo
+// evolui deliberadamente, não por acidente de refactor.
+// ============================================================================
+
+type jsonNFe struct {
+	ChaveAcesso      string  `json:"chave_acesso"`
+	HashIntegridade  string  `json:"hash_integridade"`
+	Modelo           int     `json:"modelo"`
+	Serie            int     `json:"serie"`
+	Numero           int     `json:"numero"`
+	EmissaoDate      string  `json:"emissao_date"`
+	TipoOperacao     int     `json:"tipo_operacao"`
+	TipoAmbiente     int     `json:"tipo_ambiente"`
+	NaturezaOperacao string  `json:"natureza_operacao"`
+	ProtocoloAut     string  `json:"protocolo_aut"`
+	DataAutorizacao  string  `json:"data_autorizacao"`
+	CodigoStatus     int     `json:"codigo_status"`
+	EmitenteCNPJ     string  `json:"emitente_cnpj"`
+	EmitenteRazao    string  `json:"emitente_razao"`
+	DestCNPJCPF      string  `json:"dest_cnpj_cpf"`
+	DestRazao        string  `json:"dest_razao"`
+	ValorTotalNota   float64 `json:"valor_total_nota"`
+	ValorProdutos    float64 `json:"valor_produtos"`
+	ValorDesconto    float64 `json:"valor_desconto"`
+	ValorICMS        float64 `json:"valor_icms"`
+	ValorIPI         float64 `json:"valor_ipi"`
+	ValorPIS         float64 `json:"valor_pis"`
+	ValorCOFINS      float64 `json:"valor_cofins"`
+	ValorII          float64 `json:"valor_ii"`
+	ValorFrete       float64 `json:"valor_frete"`
+	ValorSeguro      float64 `json:"valor_seguro"`
+	ModalidadeFrete  int     `json:"modalidade_frete"`
+
+	SignatureValid   bool   `json:"signature_valid"`
+	SignerCNPJ       string `json:"signer_cnpj,omitempty"`
+	SignerCommonName string `json:"signer_common_name,omitempty"`
+
+	Itens      []jsonItem      `json:"itens"`
+	Duplicatas []jsonDuplicata `json:"duplicatas"`
+	Pagamentos []jsonPagamento `json:"pagamentos"`
+
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+type jsonItem struct {
+	NItem             int     `json:"n_item"`
+	Codigo            string  `json:"codigo"`
+	CodigoEAN         string  `json:"codigo_ean,omitempty"`
+	Descricao         string  `json:"descricao"`
+	NCM               string  `json:"ncm"`
+	CFOP              string  `json:"cfop"`
+	Unidade           string  `json:"unidade"`
+	Quantidade        float64 `json:"quantidade"`
+	ValorUnitario     float64 `json:"valor_unitario"`
+	ValorTotalBruto   float64 `json:"valor_total_bruto"`
+	ValorFrete        float64 `json:"valor_frete"`
+	ValorSeguro       float64 `json:"valor_seguro"`
+	ValorDesconto     float64 `json:"valor_desconto"`
+	ValorOutros       float64 `json:"valor_outros"`
+	IndTotal          int     `json:"ind_total"`
+	BaseCalculoICMS   float64 `json:"base_calculo_icms"`
+	ValorICMS         float64 `json:"valor_icms"`
+	BaseCalculoICMSST float64 `json:"base_calculo_icmsst"`
+	ValorICMSST       float64 `json:"valor_icmsst"`
+	ValorIPI          float64 `json:"valor_ipi"`
+	ValorPIS          float64 `json:"valor_pis"`
+	ValorCOFINS       float64 `json:"valor_cofins"`
+}
+
+type jsonDuplicata struct {
+	Numero         string  `json:"numero"`
+	DataVencimento string  `json:"data_vencimento"`
+	Valor          float64 `json:"valor"`
+}
+
+type jsonPagamento struct {
+	IndicadorPagamento *int    `json:"indicador_pagamento,omitempty"`
+	MeioPagamento      string  `json:"meio_pagamento"`
+	Valor              float64 `json:"valor"`
+	CNPJCredenciadora  string  `json:"cnpj_credenciadora,omitempty"`
+	BandeiraCartao     string  `json:"bandeira_cartao,omitempty"`
+	CodigoAutorizacao  string  `json:"codigo_autorizacao,omitempty"`
+}
+
+// MarshalJSON serializa p no schema snake_case de jsonNFe. XMLRaw e
+// CanonicalInfNFe ficam de fora de propósito — são conteúdo binário/XML
+// bruto, não dado analítico. O XML original é gravado em nfe_xml.xml_raw e
+// quem precisa dele deve buscá-lo por ChaveAcesso; CanonicalInfNFe não é
+// persistido e só existe no ParsedNFe em memória.
+func (p *ParsedNFe) MarshalJSON() ([]byte, error) {
+	out := jsonNFe{
+		ChaveAcesso:      p.ChaveAcesso,
+		HashIntegridade:  p.HashIntegridade,
+		Modelo:           p.Modelo,
+		Serie:            p.Serie,
+		Numero:           p.Numero,
+		EmissaoDate:      p.EmissaoDate,
+		TipoOperacao:     p.TipoOperacao,
+		TipoAmbiente:     p.TipoAmbiente,
+		NaturezaOperacao: p.NaturezaOperacao,
+		ProtocoloAut:     p.ProtocoloAut,
+		DataAutorizacao:  p.DataAutorizacao,
+		CodigoStatus:     p.CodigoStatus,
+		EmitenteCNPJ:     p.EmitenteCNPJ,
+		EmitenteRazao:    p.EmitenteRazao,
+		DestCNPJCPF:      p.DestCNPJCPF,
+		DestRazao:        p.DestRazao,
+		ValorTotalNota:   p.ValorTotalNota,
+		ValorProdutos:    p.ValorProdutos,
+		ValorDesconto:    p.ValorDesconto,
+		ValorICMS:        p.ValorICMS,
+		ValorIPI:         p.ValorIPI,
+		ValorPIS:         p.ValorPIS,
+		ValorCOFINS:      p.ValorCOFINS,
+		ValorII:          p.ValorII,
+		ValorFrete:       p.ValorFrete,
+		ValorSeguro:      p.ValorSeguro,
+		ModalidadeFrete:  p.ModalidadeFrete,
+		SignatureValid:   p.SignatureValid,
+		SignerCNPJ:       p.SignerCNPJ,
+		SignerCommonName: p.SignerCommonName,
+		Violations:       p.Violations,
+	}
+
+	for _, it := range p.Itens {
+		out.Itens = append(out.Itens, jsonItem{
+			NItem:             it.NItem,
+			Codigo:            it.Codigo,
+			CodigoEAN:         it.CodigoEAN,
+			Descricao:         it.Descricao,
+			NCM:               it.NCM,
+			CFOP:              it.CFOP,
+			Unidade:           it.Unidade,
+			Quantidade:        it.Quantidade,
+			ValorUnitario:     it.ValorUnitario,
+			ValorTotalBruto:   it.ValorTotalBruto,
+			ValorFrete:        it.ValorFrete,
+			ValorSeguro:       it.ValorSeguro,
+			ValorDesconto:     it.ValorDesconto,
+			ValorOutros:       it.ValorOutros,
+			IndTotal:          it.IndTotal,
+			BaseCalculoICMS:   it.BaseCalculoICMS,
+			ValorICMS:         it.ValorICMS,
+			BaseCalculoICMSST: it.BaseCalculoICMSST,
+			ValorICMSST:       it.ValorICMSST,
+			ValorIPI:          it.ValorIPI,
+			ValorPIS:          it.ValorPIS,
+			ValorCOFINS:       it.ValorCOFINS,
+		})
+	}
+
+	for _, du := range p.Duplicatas {
+		out.Duplicatas = append(out.Duplicatas, jsonDuplicata{
+			Numero:         du.Numero,
+			DataVencimento: du.DataVencimento,
+			Valor:          du.Valor,
+		})
+	}
+
+	for _, pg := range p.Pagamentos {
+		out.Pagamentos = append(out.Pagamentos, jsonPagamento{
+			IndicadorPagamento: pg.IndicadorPagamento,
+			MeioPagamento:      pg.MeioPagamento,
+			Valor:              pg.Valor,
+			CNPJCredenciadora:  pg.CNPJCredenciadora,
+			BandeiraCartao:     pg.BandeiraCartao,
+			CodigoAutorizacao:  pg.CodigoAutorizacao,
+		})
+	}
+
+	return json.Marshal(out)
+}
@@ -21,34 +21,48 @@ import (
 // ParsedNFe é o objeto completo pra alimentar as tabelas nfe + relacionamentos.
 type ParsedNFe struct {
 	// Tabela nfe
-	ChaveAcesso       string
-	HashIntegridade   string
-	Modelo            int
-	Serie             int
-	Numero            int
-	EmissaoDate       string // YYYY-MM-DD
-	TipoOperacao      int
-	TipoAmbiente      int
-	NaturezaOperacao  string
-	ProtocoloAut      string
-	DataAutorizacao   string // YYYY-MM-DD
-	CodigoStatus      int
-	EmitenteCNPJ      string
-	EmitenteRazao     string
-	DestCNPJCPF       string
-	DestRazao         string
-	ValorTotalNota    float64
-	ValorProdutos     float64
-	ValorDesconto     float64
-	ValorICMS         float64
-	ValorIPI          float64
-	ValorPIS          float64
-	ValorCOFINS       float64
-	ValorII           float64
-	ValorFrete        float64
-	ValorSeguro       float64
-	ModalidadeFrete   int
-	XMLRaw            []byte
+	ChaveAcesso      string
+	HashIntegridade  string
+	Modelo           int
+	Serie            int
+	Numero           int
+	EmissaoDate      string // YYYY-MM-DD
+	TipoOperacao     int
+	TipoAmbiente     int
+	NaturezaOperacao string
+	ProtocoloAut     string
+	DataAutorizacao  string // YYYY-MM-DD
+	CodigoStatus     int
+	EmitenteCNPJ     string
+	EmitenteRazao    string
+	DestCNPJCPF      string
+	DestRazao        string
+	ValorTotalNota   float64
+	ValorProdutos    float64
+	ValorDesconto    float64
+	ValorICMS        float64
+	ValorIPI         float64
+	ValorPIS         float64
+	ValorCOFINS      float64
+	ValorII          float64
+	ValorFrete       float64
+	ValorSeguro      float64
+	ModalidadeFrete  int
+	XMLRaw           []byte
+
+	// Assinatura XML-DSig (preenchidos só quando NFE_SIGNATURE_ENABLED está
+	// ativo; ver VerifySignature em signature.go)
+	SignatureValid   bool
+	SignerCNPJ       string
+	SignerCommonName string
+	CertNotAfter     time.Time
+	CanonicalInfNFe  []byte
+
+	// Violations são os achados da validação semântica (ver validate.go) —
+	// preenchido por ParseFile com DefaultRules (ou as rules de WithRules).
+	// ParseBytes sozinho não roda validação semântica, só quem monta o
+	// ParsedNFe chamando ParseFile é que ganha isso de graça.
+	Violations []Violation
 
 	// Tabela nfe_item
 	Itens []ParsedItem
@@ -61,34 +75,34 @@ type ParsedNFe struct {
 }
 
 type ParsedItem struct {
-	NItem              int
-	Codigo             string
-	CodigoEAN          string
-	Descricao          string
-	NCM                string
-	CFOP               string
-	Unidade            string
-	Quantidade         float64
-	ValorUnitario      float64
-	ValorTotalBruto    float64
-	ValorFrete         float64
-	ValorSeguro        float64
-	ValorDesconto      float64
-	ValorOutros        float64
-	IndTotal           int
-	BaseCalculoICMS    float64
-	ValorICMS          float64
-	BaseCalculoICMSST  float64
-	ValorICMSST        float64
-	ValorIPI           float64
-	ValorPIS           float64
-	ValorCOFINS        float64
+	NItem             int
+	Codigo            string
+	CodigoEAN         string
+	Descricao         string
+	NCM               string
+	CFOP              string
+	Unidade           string
+	Quantidade        float64
+	ValorUnitario     float64
+	ValorTotalBruto   float64
+	ValorFrete        float64
+	ValorSeguro       float64
+	ValorDesconto     float64
+	ValorOutros       float64
+	IndTotal          int
+	BaseCalculoICMS   float64
+	ValorICMS         float64
+	BaseCalculoICMSST float64
+	ValorICMSST       float64
+	ValorIPI          float64
+	ValorPIS          float64
+	ValorCOFINS       float64
 }
 
 type ParsedDuplicata struct {
-	Numero          string
-	DataVencimento  string // YYYY-MM-DD
-	Valor           float64
+	Numero         string
+	DataVencimento string // YYYY-MM-DD
+	Valor          float64
 }
 
 type ParsedPagamento struct {
@@ -184,26 +198,26 @@ type icmsTot struct {
 // ------------------------- Itens (det/prod/imposto) -------------------------
 
 type det struct {
-	NItem   string   `xml:"nItem,attr"`
-	Prod    prod     `xml:"prod"`
-	Imposto imposto  `xml:"imposto"`
+	NItem   string  `xml:"nItem,attr"`
+	Prod    prod    `xml:"prod"`
+	Imposto imposto `xml:"imposto"`
 }
 
 type prod struct {
-	CProd   string `xml:"cProd"`
-	CEAN    string `xml:"cEAN"`
-	XProd   string `xml:"xProd"`
-	NCM     string `xml:"NCM"`
-	CFOP    string `xml:"CFOP"`
-	UCom    string `xml:"uCom"`
-	QCom    string `xml:"qCom"`
-	VUnCom  string `xml:"vUnCom"`
-	VProd   string `xml:"vProd"`
-	VFrete  string `xml:"vFrete"`
-	VSeg    string `xml:"vSeg"`
-	VDesc   string `xml:"vDesc"`
-	VOutro  string `xml:"vOutro"`
-	IndTot  string `xml:"indTot"`
+	CProd  string `xml:"cProd"`
+	CEAN   string `xml:"cEAN"`
+	XProd  string `xml:"xProd"`
+	NCM    string `xml:"NCM"`
+	CFOP   string `xml:"CFOP"`
+	UCom   string `xml:"uCom"`
+	QCom   string `xml:"qCom"`
+	VUnCom string `xml:"vUnCom"`
+	VProd  string `xml:"vProd"`
+	VFrete string `xml:"vFrete"`
+	VSeg   string `xml:"vSeg"`
+	VDesc  string `xml:"vDesc"`
+	VOutro string `xml:"vOutro"`
+	IndTot string `xml:"indTot"`
 }
 
 type imposto struct {
@@ -239,9 +253,9 @@ type icmsVal struct {
 }
 
 type icmsSTVal struct {
-	VBC    string `xml:"vBC"`
-	VICMS  string `xml:"vICMS"`
-	VBCST  string `xml:"vBCST"`
+	VBC     string `xml:"vBC"`
+	VICMS   string `xml:"vICMS"`
+	VBCST   string `xml:"vBCST"`
 	VICMSST string `xml:"vICMSST"`
 }
 
@@ -317,58 +331,106 @@ type detPag struct {
 }
 
 type card struct {
-	CNPJ    string `xml:"CNPJ"`
+	CNPJ     string `xml:"CNPJ"`
 	Bandeira string `xml:"tBand"`
-	Aut     string `xml:"cAut"`
+	Aut      string `xml:"cAut"`
 }
 
 // ============================================================================
 // Função principal de parse + XSD
 // ============================================================================
 
-func ParseFile(path string) (*ParsedNFe, error) {
+// ParseFile lê e parseia um XML de NF-e do disco, e em seguida roda a
+// validação semântica (ver validate.go) sobre o resultado: por padrão
+// DefaultRules, ou as rules passadas via WithRules. Os call sites do
+// worker continuam chamando ParseFile(path) sem opts, o que preserva o
+// comportamento anterior além de ganhar ParsedNFe.Violations de graça;
+// admin.reprocess usa ParseBytes e por isso não roda validação semântica.
+func ParseFile(path string, opts ...ParseOption) (*ParsedNFe, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("erro lendo XML %s: %w", path, err)
 	}
 
+	parsed, err := ParseBytes(data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := parseConfig{rules: DefaultRules()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	parsed.Violations = Validate(parsed, cfg.rules...)
+
+	return parsed, nil
+}
+
+// ParseBytes faz o parse de um XML de NF-e já em memória, sem depender de
+// um arquivo no disco. `label` só é usado para compor mensagens de erro
+// (tipicamente o path de origem, ou algo como "nfe_xml:<chave>" quando o
+// conteúdo vem do banco, como em admin.reprocess).
+func ParseBytes(data []byte, label string) (*ParsedNFe, error) {
+	return parseBytes(data, label, nil)
+}
+
+// parseBytes é o corpo de ParseBytes, parametrizado por um validador XSD
+// compartilhado opcional. shared == nil reproduz o comportamento histórico
+// de ParseBytes (Init/NewXsdHandlerUrl/Cleanup por chamada); shared != nil é
+// usado por ParseDir, que monta um único XsdHandler pra todo o lote em vez
+// de pagar esse custo por arquivo (ver batch.go).
+func parseBytes(data []byte, label string, shared *sharedXSDValidator) (*ParsedNFe, error) {
 	// hash_integridade = SHA-256 do XML bruto
 	hash := sha256.Sum256(data)
 	hashHex := hex.EncodeToString(hash[:])
 
 	// Validação XSD (opcional, controlada por env)
 	xsdEnabled := strings.ToLower(os.Getenv("NFE_XSD_ENABLED"))
-	if xsdEnabled == "true" || xsdEnabled == "1" || xsdEnabled == "yes" {
-		xsdDir := os.Getenv("NFE_XSD_DIR")
-		xsdMain := os.Getenv("NFE_XSD_MAIN")
-		if xsdDir == "" {
-			return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_DIR não foi definido")
-		}
-		if xsdMain == "" {
-			return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_MAIN não foi definido (ex: procNFe_v4.00.xsd)")
-		}
-		xsdPath, err := resolveXSDPath(xsdDir, xsdMain)
-		if err != nil {
-			return nil, err
-		}
-		if err := validateXMLWithXSD(data, xsdPath); err != nil {
-			return nil, err
+	if shared != nil || xsdEnabled == "true" || xsdEnabled == "1" || xsdEnabled == "yes" {
+		if shared != nil {
+			if err := shared.ValidateMem(data); err != nil {
+				return nil, err
+			}
+		} else {
+			xsdDir := os.Getenv("NFE_XSD_DIR")
+			xsdMain := os.Getenv("NFE_XSD_MAIN")
+			if xsdDir == "" {
+				return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_DIR não foi definido")
+			}
+			if xsdMain == "" {
+				return nil, fmt.Errorf("NFE_XSD_ENABLED=true mas NFE_XSD_MAIN não foi definido (ex: procNFe_v4.00.xsd)")
+			}
+			xsdPath, err := resolveXSDPath(xsdDir, xsdMain)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateXMLWithXSD(data, xsdPath); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	// 1) tenta nfeProc
 	var proc nfeProc
 	if err := xml.Unmarshal(data, &proc); err == nil && proc.NFe.InfNFe.Ide.Modelo != 0 {
-		return buildParsedFrom(proc, data, hashHex)
+		parsed, err := buildParsedFrom(proc, data, hashHex)
+		if err != nil {
+			return nil, err
+		}
+		return applySignatureCheck(parsed, data)
 	}
 
 	// 2) tenta NFe "simples"
 	var n nfe
 	if err := xml.Unmarshal(data, &n); err == nil && n.InfNFe.Ide.Modelo != 0 {
-		return buildParsedFrom(n, data, hashHex)
+		parsed, err := buildParsedFrom(n, data, hashHex)
+		if err != nil {
+			return nil, err
+		}
+		return applySignatureCheck(parsed, data)
 	}
 
-	return nil, fmt.Errorf("XML não reconhecido como nfeProc ou NFe (arquivo: %s)", path)
+	return nil, fmt.Errorf("XML não reconhecido como nfeProc ou NFe (origem: %s)", label)
 }
 
 func buildParsedFrom(v interface{}, xmlRaw []byte, hashHex string) (*ParsedNFe, error) {
@@ -693,6 +755,33 @@ func validateXMLWithXSD(xmlData []byte, xsdPath string) error {
 	return nil
 }
 
+// applySignatureCheck roda a verificação de assinatura XML-DSig (opcional,
+// controlada por NFE_SIGNATURE_ENABLED) sobre parsed, no mesmo padrão do
+// switch de XSD acima: desabilitado por padrão, e quando habilitado uma
+// assinatura inválida vira erro de parse (não um parsed com
+// SignatureValid=false silencioso).
+func applySignatureCheck(parsed *ParsedNFe, data []byte) (*ParsedNFe, error) {
+	sigEnabled := strings.ToLower(os.Getenv("NFE_SIGNATURE_ENABLED"))
+	if sigEnabled != "true" && sigEnabled != "1" && sigEnabled != "yes" {
+		return parsed, nil
+	}
+
+	result, err := verifySignatureDetailed(data, os.Getenv("NFE_TRUSTSTORE_DIR"))
+	if err != nil {
+		return nil, fmt.Errorf("NFE_SIGNATURE_ENABLED=true mas a assinatura não pôde ser verificada: %w", err)
+	}
+	if !result.Valid {
+		return nil, fmt.Errorf("NFE_SIGNATURE_ENABLED=true e a assinatura do XML é inválida")
+	}
+
+	parsed.SignatureValid = result.Valid
+	parsed.SignerCNPJ = result.SignerCNPJ
+	parsed.SignerCommonName = result.SignerCommonName
+	parsed.CertNotAfter = result.CertNotAfter
+	parsed.CanonicalInfNFe = result.CanonicalInfNFe
+	return parsed, nil
+}
+
 func resolveXSDPath(baseDir, xsdFile string) (string, error) {
 	if xsdFile == "" {
 		return "", fmt.Errorf("NFE_XSD_MAIN não definido")
@@ -0,0 +1,500 @@
+package nfe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Verificação de assinatura XMLDSig envelopada (NF-e / protNFe)
+//
+// A SEFAZ exige uma assinatura XML-DSig "enveloped" sobre o elemento
+// <infNFe Id="..."> de toda NF-e autorizada (e, em alguns retornos, também
+// sobre <infProt> dentro de <protNFe>). VerifySignature reimplementa as
+// quatro etapas do algoritmo usando só a stdlib (sem puxar uma dependência
+// nova de XML-DSig, já que o resto do pacote nfe não depende de nenhuma):
+//
+//  1. localizar <Signature> dentro do elemento assinado;
+//  2. canonicalizar (xml-exc-c14n) o nó referenciado por <Reference URI="#Id">
+//     e comparar o digest contra <DigestValue>;
+//  3. canonicalizar <SignedInfo> e verificar a assinatura RSA contra o
+//     certificado X.509 embutido em <KeyInfo>/<X509Data>/<X509Certificate>;
+//  4. validar a cadeia desse certificado contra as CAs raiz da ICP-Brasil
+//     em NFE_TRUSTSTORE_DIR (ou SignatureOptions.TrustStoreDir).
+//
+// O canonicalizador (canonicalizeExcC14N) implementa só o subconjunto de
+// xml-exc-c14n que o XML de NF-e de fato usa na prática: um namespace
+// default herdado do ancestral, sem prefixos múltiplos, sem
+// InclusiveNamespaces PrefixList e sem comentários preservados (modo
+// "WithComments" do c14n). Não é uma implementação completa do algoritmo
+// W3C — documentos com namespaces mais elaborados podem canonicalizar
+// diferente de uma lib de referência (ex: Apache Santuário) e falhar a
+// verificação por isso, em vez de por assinatura realmente inválida.
+// ============================================================================
+
+// SignatureOptions configura VerifySignature. Zero-value usa
+// NFE_TRUSTSTORE_DIR no momento da chamada, do mesmo jeito que o switch de
+// XSD usa NFE_XSD_DIR/NFE_XSD_MAIN.
+type SignatureOptions struct {
+	// TrustStoreDir é o diretório com certificados de CA raiz (PEM) contra
+	// os quais a cadeia do certificado assinante é validada. Tipicamente as
+	// ACs raiz da ICP-Brasil.
+	TrustStoreDir string
+}
+
+// SignatureResult é o que VerifySignature apura sobre a assinatura, exposto
+// via os novos campos de ParsedNFe (SignatureValid, SignerCNPJ, etc.).
+type SignatureResult struct {
+	Valid            bool
+	SignerCNPJ       string
+	SignerCommonName string
+	CertNotAfter     time.Time
+	CanonicalInfNFe  []byte // bytes canonicalizados de <infNFe>, pra reverificação sem reparsear
+}
+
+func trustStoreDirFrom(opts []SignatureOptions) string {
+	if len(opts) > 0 && opts[0].TrustStoreDir != "" {
+		return opts[0].TrustStoreDir
+	}
+	return os.Getenv("NFE_TRUSTSTORE_DIR")
+}
+
+// VerifySignature valida a assinatura XML-DSig envelopada encontrada dentro
+// de <NFe> (ou, na falta dele, de <infNFe>). A assinatura de <protNFe> não é
+// verificada: um XML sem NFe/infNFe devolve erro. Devolve nil se a
+// assinatura é válida e a cadeia do certificado assinante confere com o
+// trust store; qualquer outra combinação devolve um erro descrevendo o que
+// falhou.
+func VerifySignature(data []byte, opts ...SignatureOptions) error {
+	_, err := verifySignatureDetailed(data, trustStoreDirFrom(opts))
+	return err
+}
+
+func verifySignatureDetailed(data []byte, trustStoreDir string) (SignatureResult, error) {
+	var result SignatureResult
+
+	if trustStoreDir == "" {
+		return result, fmt.Errorf("NFE_TRUSTSTORE_DIR não definido e nenhum SignatureOptions.TrustStoreDir informado")
+	}
+
+	root, err := parseXMLNode(data)
+	if err != nil {
+		return result, fmt.Errorf("erro parseando XML pra verificação de assinatura: %w", err)
+	}
+
+	signed := findByLocalName(root, "NFe")
+	if signed == nil {
+		signed = findByLocalName(root, "infNFe")
+	}
+	if signed == nil {
+		return result, fmt.Errorf("elemento NFe/infNFe não encontrado no XML")
+	}
+
+	sigEl := findByLocalName(signed, "Signature")
+	if sigEl == nil {
+		return result, fmt.Errorf("elemento Signature não encontrado")
+	}
+
+	signedInfo := findByLocalName(sigEl, "SignedInfo")
+	if signedInfo == nil {
+		return result, fmt.Errorf("elemento SignedInfo não encontrado dentro de Signature")
+	}
+
+	// --- 1) digest da referência -------------------------------------------------
+	refEl := findByLocalName(signedInfo, "Reference")
+	if refEl == nil {
+		return result, fmt.Errorf("elemento Reference não encontrado dentro de SignedInfo")
+	}
+	refURI := strings.TrimPrefix(attrValue(refEl, "URI"), "#")
+
+	referenced := findByID(root, refURI)
+	if referenced == nil {
+		return result, fmt.Errorf("elemento referenciado por Reference URI=%q não encontrado", refURI)
+	}
+
+	digestMethodURI := attrValue(findByLocalName(refEl, "DigestMethod"), "Algorithm")
+	wantDigest, err := base64.StdEncoding.DecodeString(collapseSpace(textOf(findByLocalName(refEl, "DigestValue"))))
+	if err != nil {
+		return result, fmt.Errorf("erro decodificando DigestValue: %w", err)
+	}
+
+	canonicalRef := canonicalizeExcC14N(referenced, namespacesInScope(root, referenced))
+	gotDigest, err := digestFor(digestMethodURI, canonicalRef)
+	if err != nil {
+		return result, err
+	}
+	if !bytes.Equal(gotDigest, wantDigest) {
+		return result, fmt.Errorf("digest do elemento referenciado (#%s) não confere: assinatura inválida ou canonicalização divergente", refURI)
+	}
+	result.CanonicalInfNFe = canonicalRef
+
+	// --- 2) certificado X.509 embutido -------------------------------------------
+	certB64 := collapseSpace(textOf(findByLocalName(sigEl, "X509Certificate")))
+	if certB64 == "" {
+		return result, fmt.Errorf("X509Certificate não encontrado em KeyInfo")
+	}
+	certDER, err := base64.StdEncoding.DecodeString(certB64)
+	if err != nil {
+		return result, fmt.Errorf("erro decodificando X509Certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return result, fmt.Errorf("erro parseando certificado do assinante: %w", err)
+	}
+	result.SignerCommonName = cert.Subject.CommonName
+	result.SignerCNPJ = extractCNPJFromCertificate(cert)
+	result.CertNotAfter = cert.NotAfter
+
+	// --- 3) assinatura RSA sobre SignedInfo --------------------------------------
+	sigMethodURI := attrValue(findByLocalName(signedInfo, "SignatureMethod"), "Algorithm")
+	sigValue, err := base64.StdEncoding.DecodeString(collapseSpace(textOf(findByLocalName(sigEl, "SignatureValue"))))
+	if err != nil {
+		return result, fmt.Errorf("erro decodificando SignatureValue: %w", err)
+	}
+
+	canonicalSignedInfo := canonicalizeExcC14N(signedInfo, namespacesInScope(root, signedInfo))
+	if err := verifyRSASignature(cert, sigMethodURI, canonicalSignedInfo, sigValue); err != nil {
+		return result, fmt.Errorf("assinatura RSA inválida: %w", err)
+	}
+
+	// --- 4) cadeia de certificação contra o trust store --------------------------
+	roots, err := loadTrustStore(trustStoreDir)
+	if err != nil {
+		return result, err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return result, fmt.Errorf("cadeia de certificação do assinante não confere com %s: %w", trustStoreDir, err)
+	}
+
+	result.Valid = true
+	return result, nil
+}
+
+// cnpjInCertRe extrai uma sequência de 14 dígitos do CN do certificado (ou,
+// na falta, dos campos O/Organization do Subject; o SAN não é consultado).
+// Certificados e-CNPJ da ICP-Brasil costumam codificar o CNPJ como sufixo do
+// CN no formato "RAZAO SOCIAL:12345678000199" — não há garantia formal
+// disso fora da política de cada AC, então isso é heurístico, não uma
+// leitura de OID padronizado.
+var cnpjInCertRe = regexp.MustCompile(`\d{14}`)
+
+func extractCNPJFromCertificate(cert *x509.Certificate) string {
+	if m := cnpjInCertRe.FindString(cert.Subject.CommonName); m != "" {
+		return m
+	}
+	for _, name := range cert.Subject.Organization {
+		if m := cnpjInCertRe.FindString(name); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+func digestFor(algorithmURI string, data []byte) ([]byte, error) {
+	switch algorithmURI {
+	case "http://www.w3.org/2000/09/xmldsig#sha1":
+		sum := sha1.Sum(data)
+		return sum[:], nil
+	case "http://www.w3.org/2001/04/xmlenc#sha256":
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	default:
+		return nil, fmt.Errorf("DigestMethod %q não suportado (só sha1/sha256)", algorithmURI)
+	}
+}
+
+func verifyRSASignature(cert *x509.Certificate, algorithmURI string, signedInfo, signature []byte) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("certificado não usa chave RSA (%T)", cert.PublicKey)
+	}
+
+	var hashed []byte
+	var hashType crypto.Hash
+	switch algorithmURI {
+	case "http://www.w3.org/2000/09/xmldsig#rsa-sha1":
+		sum := sha1.Sum(signedInfo)
+		hashed = sum[:]
+		hashType = crypto.SHA1
+	case "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":
+		sum := sha256.Sum256(signedInfo)
+		hashed = sum[:]
+		hashType = crypto.SHA256
+	default:
+		return fmt.Errorf("SignatureMethod %q não suportado (só rsa-sha1/rsa-sha256)", algorithmURI)
+	}
+
+	return rsa.VerifyPKCS1v15(pub, hashType, hashed, signature)
+}
+
+// loadTrustStore lê todos os certificados PEM de trustStoreDir (ACs raiz da
+// ICP-Brasil, um .pem/.crt por arquivo ou vários concatenados) num
+// x509.CertPool.
+func loadTrustStore(dir string) (*x509.CertPool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo NFE_TRUSTSTORE_DIR %s: %w", dir, err)
+	}
+
+	pool := x509.NewCertPool()
+	found := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".pem" && ext != ".crt" {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("erro lendo CA raiz %s: %w", entry.Name(), err)
+		}
+		if pool.AppendCertsFromPEM(raw) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("nenhuma CA raiz válida encontrada em %s", dir)
+	}
+	return pool, nil
+}
+
+// ============================================================================
+// Micro-DOM auxiliar só pro que a verificação de assinatura precisa: não
+// substitui o parse struct-based do resto do pacote (buildParsedFrom etc),
+// que continua usando encoding/xml.Unmarshal direto nos tipos nfeProc/nfe.
+// ============================================================================
+
+type xmlNode struct {
+	Space, Local string
+	Attrs        []xml.Attr
+	Children     []*xmlNode
+	Text         string
+	Parent       *xmlNode
+}
+
+func parseXMLNode(data []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	var stack []*xmlNode
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Space: t.Name.Space, Local: t.Name.Local, Attrs: append([]xml.Attr(nil), t.Attr...)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				node.Parent = parent
+				parent.Children = append(parent.Children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("XML vazio ou sem elemento raiz")
+	}
+	return root, nil
+}
+
+func findByLocalName(n *xmlNode, local string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	if n.Local == local {
+		return n
+	}
+	for _, c := range n.Children {
+		if found := findByLocalName(c, local); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findByID procura, em toda a árvore a partir de n, o elemento cujo
+// atributo Id (ou ID) bate com id — é assim que Reference URI="#X" resolve
+// o nó canonicalizado pro cálculo do digest.
+func findByID(n *xmlNode, id string) *xmlNode {
+	if n == nil || id == "" {
+		return nil
+	}
+	if attrValue(n, "Id") == id || attrValue(n, "ID") == id {
+		return n
+	}
+	for _, c := range n.Children {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attrValue(n *xmlNode, local string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func textOf(n *xmlNode) string {
+	if n == nil {
+		return ""
+	}
+	return n.Text
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// namespacesInScope reconstrói, subindo de target até root, o mapa de
+// declarações xmlns (prefix -> URI, prefix "" pro default namespace) que
+// estão em vigor em target — necessário porque target normalmente herda o
+// xmlns default declarado lá em cima em <NFe>, não no próprio nó, e
+// xml-exc-c14n precisa reemitir essas declarações "visivelmente utilizadas"
+// quando canonicaliza o subtree isoladamente do resto do documento.
+func namespacesInScope(root, target *xmlNode) map[string]string {
+	var chain []*xmlNode
+	for n := target; n != nil; n = n.Parent {
+		chain = append(chain, n)
+	}
+
+	ns := map[string]string{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, a := range chain[i].Attrs {
+			if a.Name.Space == "xmlns" {
+				ns[a.Name.Local] = a.Value
+			} else if a.Name.Local == "xmlns" && a.Name.Space == "" {
+				ns[""] = a.Value
+			}
+		}
+	}
+	return ns
+}
+
+// canonicalizeExcC14N serializa node no subconjunto de xml-exc-c14n descrito
+// no comentário do topo do arquivo: reemite em node o namespace default
+// herdado (se node ainda não o declarar), ordena atributos por nome local,
+// omite comentários e processing instructions (não suportados pelo
+// micro-DOM acima) e normaliza espaços em branco de atributo como exige o
+// Canonical XML 1.0 (§ Attribute Value Normalization já foi feita pelo
+// parser de encoding/xml; aqui só achatamos quebras de linha remanescentes).
+func canonicalizeExcC14N(node *xmlNode, inherited map[string]string) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, node, inherited, true)
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, node *xmlNode, inherited map[string]string, isRoot bool) {
+	// node.Space é ignorado de propósito: o XML de NF-e só usa um
+	// namespace default (sem prefixos), então o nome local já é
+	// suficiente — ver limitação documentada no comentário do arquivo.
+	name := node.Local
+
+	buf.WriteByte('<')
+	buf.WriteString(name)
+
+	declaredDefault := false
+	attrs := append([]xml.Attr(nil), node.Attrs...)
+	for _, a := range attrs {
+		if a.Name.Local == "xmlns" && a.Name.Space == "" {
+			declaredDefault = true
+		}
+	}
+
+	// xmlns default herdado: só precisa ser reemitido na raiz do subtree
+	// canonicalizado, já que filhos continuam "vendo" o que o pai já
+	// declarou dentro do próprio subtree serializado.
+	if isRoot && !declaredDefault {
+		if uri, ok := inherited[""]; ok && uri != "" {
+			buf.WriteString(` xmlns="`)
+			buf.WriteString(escapeAttr(uri))
+			buf.WriteByte('"')
+		}
+	}
+
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		if a.Name.Space != "" && a.Name.Space != "xmlns" {
+			buf.WriteString(a.Name.Space)
+			buf.WriteByte(':')
+		}
+		buf.WriteString(a.Name.Local)
+		buf.WriteString(`="`)
+		buf.WriteString(escapeAttr(collapseNewlines(a.Value)))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	if len(node.Children) == 0 {
+		buf.WriteString(escapeText(node.Text))
+	} else {
+		for _, c := range node.Children {
+			writeCanonical(buf, c, inherited, false)
+		}
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(name)
+	buf.WriteByte('>')
+}
+
+func collapseNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", " ")
+	s = strings.ReplaceAll(s, "\n", " ")
+	s = strings.ReplaceAll(s, "\t", " ")
+	return s
+}
+
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return s
+}
+
+func escapeAttr(s string) string {
+	s = escapeText(s)
+	s = strings.ReplaceAll(s, `"`, "&quot;")
+	return s
+}
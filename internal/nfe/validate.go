@@ -0,0 +1,375 @@
+package nfe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Validação semântica pós-parse
+//
+// XSD (validateXMLWithXSD) só garante que o XML tem a forma certa; não
+// garante que os números batem entre si nem que a chave de acesso ou os
+// documentos fiscais embutidos são matematicamente consistentes. Validate
+// roda uma cadeia de Rule contra um *ParsedNFe já montado — por padrão as
+// regras de DefaultRules, que ParseFile aplica automaticamente a menos que
+// o chamador passe WithRules com seu próprio conjunto.
+// ============================================================================
+
+// Severity classifica o quão grave é uma Violation: Warn é algo que vale
+// registrar mas não bloqueia a ingestão (ex: pequena divergência de
+// arredondamento); Error é uma inconsistência que normalmente indica XML
+// corrompido ou gerado errado.
+type Severity string
+
+const (
+	SeverityWarn  Severity = "warn"
+	SeverityError Severity = "error"
+)
+
+// Violation é um problema semântico encontrado por uma Rule. Code é estável
+// e pensado pra ser usado por quem consome (ETL decidindo hard-fail vs
+// anotar); Message é a descrição legível.
+type Violation struct {
+	Code     string
+	Severity Severity
+	Field    string
+	Message  string
+}
+
+// Rule é uma regra semântica aplicada a um *ParsedNFe já montado.
+type Rule interface {
+	Name() string
+	Check(p *ParsedNFe) []Violation
+}
+
+// Validate roda cada rule contra p e concatena as violações encontradas, na
+// ordem em que as rules foram passadas.
+func Validate(p *ParsedNFe, rules ...Rule) []Violation {
+	var violations []Violation
+	for _, r := range rules {
+		violations = append(violations, r.Check(p)...)
+	}
+	return violations
+}
+
+// defaultEpsilon é a tolerância usada pelas rules de soma monetária quando
+// nenhum Epsilon explícito é informado — 2 centavos, suficiente pra
+// absorver arredondamento de rateio de frete/desconto entre itens sem
+// deixar passar divergência de verdade.
+const defaultEpsilon = 0.02
+
+// parseConfig carrega as opções de ParseFile; hoje só o conjunto de rules,
+// mas é o mesmo padrão de "struct de config populado por funcionais" que
+// BatchOptions já usa pra ParseDir.
+type parseConfig struct {
+	rules []Rule
+}
+
+// ParseOption configura ParseFile.
+type ParseOption func(*parseConfig)
+
+// WithRules troca o conjunto de regras que ParseFile roda após o parse por
+// rules, no lugar de DefaultRules(). Passar WithRules() (sem nenhuma regra)
+// desliga a validação semântica por completo.
+func WithRules(rules ...Rule) ParseOption {
+	return func(c *parseConfig) {
+		c.rules = rules
+	}
+}
+
+// DefaultRules devolve o conjunto de regras que ParseFile aplica quando
+// nenhum WithRules é passado.
+func DefaultRules() []Rule {
+	return []Rule{
+		chaveAcessoRule{},
+		valorTotalRule{},
+		itensTotalRule{},
+		pagamentosTotalRule{},
+		documentoFiscalRule{},
+		emissaoDataRule{},
+	}
+}
+
+// --------------------------- (1) chave de acesso ---------------------------
+
+// chaveAcessoRule confere o dígito verificador mod-11 da chave de acesso
+// contra os 43 dígitos que o precedem (UF+AAMM+CNPJ+mod+serie+nNF+tpEmis+cNF
+// — os 43 primeiros dígitos da própria ChaveAcesso, na ordem em que o
+// layout da NF-e já os concatena).
+type chaveAcessoRule struct{}
+
+func (chaveAcessoRule) Name() string { return "chave_acesso_dv" }
+
+func (chaveAcessoRule) Check(p *ParsedNFe) []Violation {
+	chave := p.ChaveAcesso
+	if len(chave) != 44 {
+		return []Violation{{
+			Code: "chave_acesso_tamanho", Severity: SeverityError, Field: "ChaveAcesso",
+			Message: fmt.Sprintf("chave de acesso com %d dígitos, esperado 44", len(chave)),
+		}}
+	}
+
+	dv, err := chaveAcessoCheckDigit(chave[:43])
+	if err != nil {
+		return []Violation{{
+			Code: "chave_acesso_formato", Severity: SeverityError, Field: "ChaveAcesso",
+			Message: err.Error(),
+		}}
+	}
+
+	want := int(chave[43] - '0')
+	if want < 0 || want > 9 || dv != want {
+		return []Violation{{
+			Code: "chave_acesso_dv", Severity: SeverityError, Field: "ChaveAcesso",
+			Message: fmt.Sprintf("dígito verificador da chave de acesso não confere (informado %c, calculado %d)", chave[43], dv),
+		}}
+	}
+	return nil
+}
+
+// chaveAcessoCheckDigit aplica o módulo 11 padrão da NF-e: pesos 2..9
+// ciclando da direita pra esquerda; resto 0 ou 1 vira dígito 0, senão
+// 11-resto.
+func chaveAcessoCheckDigit(digits string) (int, error) {
+	sum := 0
+	weight := 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if d < '0' || d > '9' {
+			return 0, fmt.Errorf("chave de acesso contém caractere não numérico: %q", digits)
+		}
+		sum += int(d-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	rem := sum % 11
+	if rem < 2 {
+		return 0, nil
+	}
+	return 11 - rem, nil
+}
+
+// ----------------------- (2)/(3)/(4) somas monetárias -----------------------
+
+// valorTotalRule confere vNF contra produtos-desconto+frete+seguro+ipi+ii.
+//
+// O schema real de NF-e também soma vICMSST e vOutro no total, mas este
+// parser não extrai esses dois campos no nível de cabeçalho (ver icmsTot em
+// parse.go, que não os modela) — por isso ficam de fora da conta abaixo.
+// Notas com ICMS-ST relevante ou despesas acessórias (vOutro) relevantes
+// podem disparar essa regra como falso positivo até esses campos serem
+// adicionados ao parser.
+type valorTotalRule struct{ Epsilon float64 }
+
+func (valorTotalRule) Name() string { return "valor_total_nota" }
+
+func (r valorTotalRule) Check(p *ParsedNFe) []Violation {
+	expected := p.ValorProdutos - p.ValorDesconto + p.ValorFrete + p.ValorSeguro + p.ValorIPI + p.ValorII
+	diff := math.Abs(p.ValorTotalNota - expected)
+	if diff <= epsilonOrDefault(r.Epsilon) {
+		return nil
+	}
+	return []Violation{{
+		Code: "valor_total_nota", Severity: SeverityWarn, Field: "ValorTotalNota",
+		Message: fmt.Sprintf("vNF (%.2f) difere do esperado a partir dos componentes (%.2f) em %.2f", p.ValorTotalNota, expected, diff),
+	}}
+}
+
+// itensTotalRule confere a soma de vProd dos itens com indTot=1 contra
+// ValorProdutos da nota.
+type itensTotalRule struct{ Epsilon float64 }
+
+func (itensTotalRule) Name() string { return "itens_total_bruto" }
+
+func (r itensTotalRule) Check(p *ParsedNFe) []Violation {
+	var sum float64
+	for _, it := range p.Itens {
+		if it.IndTotal == 1 {
+			sum += it.ValorTotalBruto
+		}
+	}
+	diff := math.Abs(sum - p.ValorProdutos)
+	if diff <= epsilonOrDefault(r.Epsilon) {
+		return nil
+	}
+	return []Violation{{
+		Code: "itens_total_bruto", Severity: SeverityWarn, Field: "Itens",
+		Message: fmt.Sprintf("soma de vProd dos itens com indTot=1 (%.2f) difere de vProd da nota (%.2f) em %.2f", sum, p.ValorProdutos, diff),
+	}}
+}
+
+// pagamentosTotalRule confere a soma de vPag contra vNF, só quando a nota
+// tem grupo pag (algumas notas de serviço/transporte não têm).
+type pagamentosTotalRule struct{ Epsilon float64 }
+
+func (pagamentosTotalRule) Name() string { return "pagamentos_total" }
+
+func (r pagamentosTotalRule) Check(p *ParsedNFe) []Violation {
+	if len(p.Pagamentos) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, pg := range p.Pagamentos {
+		sum += pg.Valor
+	}
+	diff := math.Abs(sum - p.ValorTotalNota)
+	if diff <= epsilonOrDefault(r.Epsilon) {
+		return nil
+	}
+	return []Violation{{
+		Code: "pagamentos_total", Severity: SeverityWarn, Field: "Pagamentos",
+		Message: fmt.Sprintf("soma de vPag (%.2f) difere de vNF (%.2f) em %.2f", sum, p.ValorTotalNota, diff),
+	}}
+}
+
+func epsilonOrDefault(e float64) float64 {
+	if e > 0 {
+		return e
+	}
+	return defaultEpsilon
+}
+
+// --------------------------- (5) CNPJ/CPF -----------------------------------
+
+// documentoFiscalRule confere os dígitos verificadores de EmitenteCNPJ e,
+// quando presente, DestCNPJCPF (14 dígitos = CNPJ, 11 = CPF).
+type documentoFiscalRule struct{}
+
+func (documentoFiscalRule) Name() string { return "documentos_fiscais_dv" }
+
+func (documentoFiscalRule) Check(p *ParsedNFe) []Violation {
+	var violations []Violation
+
+	if p.EmitenteCNPJ != "" && !validCNPJ(p.EmitenteCNPJ) {
+		violations = append(violations, Violation{
+			Code: "emitente_cnpj_dv", Severity: SeverityError, Field: "EmitenteCNPJ",
+			Message: fmt.Sprintf("CNPJ do emitente %q tem dígito verificador inválido", p.EmitenteCNPJ),
+		})
+	}
+
+	dest := strings.TrimSpace(p.DestCNPJCPF)
+	switch len(onlyDigits(dest)) {
+	case 0:
+		// sem destinatário identificado (NF-e de consumidor final sem CPF/CNPJ)
+	case 14:
+		if !validCNPJ(dest) {
+			violations = append(violations, Violation{
+				Code: "dest_cnpj_dv", Severity: SeverityError, Field: "DestCNPJCPF",
+				Message: fmt.Sprintf("CNPJ do destinatário %q tem dígito verificador inválido", dest),
+			})
+		}
+	case 11:
+		if !validCPF(dest) {
+			violations = append(violations, Violation{
+				Code: "dest_cpf_dv", Severity: SeverityError, Field: "DestCNPJCPF",
+				Message: fmt.Sprintf("CPF do destinatário %q tem dígito verificador inválido", dest),
+			})
+		}
+	default:
+		violations = append(violations, Violation{
+			Code: "dest_documento_tamanho", Severity: SeverityWarn, Field: "DestCNPJCPF",
+			Message: fmt.Sprintf("documento do destinatário com %d dígitos (esperado 11 ou 14)", len(onlyDigits(dest))),
+		})
+	}
+
+	return violations
+}
+
+// weightedMod11 soma digits[i]*weights[i] e aplica o mesmo fechamento
+// mod-11 do dígito verificador de CPF/CNPJ (resto < 2 vira 0, senão
+// 11-resto). Usado tanto pro primeiro quanto pro segundo DV de ambos.
+func weightedMod11(digits, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += digits[i] * w
+	}
+	rem := sum % 11
+	if rem < 2 {
+		return 0
+	}
+	return 11 - rem
+}
+
+func digitsOf(s string) []int {
+	out := make([]int, len(s))
+	for i := 0; i < len(s); i++ {
+		out[i] = int(s[i] - '0')
+	}
+	return out
+}
+
+func validCNPJ(s string) bool {
+	s = onlyDigits(s)
+	if len(s) != 14 {
+		return false
+	}
+	digits := digitsOf(s)
+
+	dv1 := weightedMod11(digits[:12], []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+	dv2 := weightedMod11(append(append([]int{}, digits[:12]...), dv1), []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+
+	return digits[12] == dv1 && digits[13] == dv2
+}
+
+func validCPF(s string) bool {
+	s = onlyDigits(s)
+	if len(s) != 11 {
+		return false
+	}
+	digits := digitsOf(s)
+
+	dv1 := weightedMod11(digits[:9], []int{10, 9, 8, 7, 6, 5, 4, 3, 2})
+	dv2 := weightedMod11(append(append([]int{}, digits[:9]...), dv1), []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2})
+
+	return digits[9] == dv1 && digits[10] == dv2
+}
+
+// --------------------------- (6) datas ---------------------------------
+
+// emissaoDataRule confere que EmissaoDate não está no futuro e, quando há
+// DataAutorizacao, que as duas datas não distam mais de 30 dias — uma nota
+// autorizada muito depois da emissão costuma indicar relógio de origem
+// errado ou reemissão tardia.
+type emissaoDataRule struct{}
+
+func (emissaoDataRule) Name() string { return "emissao_data" }
+
+func (emissaoDataRule) Check(p *ParsedNFe) []Violation {
+	emissao, err := time.Parse("2006-01-02", p.EmissaoDate)
+	if err != nil {
+		return []Violation{{
+			Code: "emissao_data_formato", Severity: SeverityWarn, Field: "EmissaoDate",
+			Message: fmt.Sprintf("EmissaoDate %q não está no formato YYYY-MM-DD: %v", p.EmissaoDate, err),
+		}}
+	}
+
+	var violations []Violation
+	if emissao.After(time.Now()) {
+		violations = append(violations, Violation{
+			Code: "emissao_futura", Severity: SeverityError, Field: "EmissaoDate",
+			Message: fmt.Sprintf("data de emissão %s está no futuro", p.EmissaoDate),
+		})
+	}
+
+	if p.DataAutorizacao != "" {
+		if autorizacao, err := time.Parse("2006-01-02", p.DataAutorizacao); err == nil {
+			delta := autorizacao.Sub(emissao)
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta > 30*24*time.Hour {
+				violations = append(violations, Violation{
+					Code: "emissao_autorizacao_distantes", Severity: SeverityWarn, Field: "EmissaoDate",
+					Message: fmt.Sprintf("emissão (%s) e autorização (%s) distam mais de 30 dias", p.EmissaoDate, p.DataAutorizacao),
+				})
+			}
+		}
+	}
+
+	return violations
+}
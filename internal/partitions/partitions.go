@@ -0,0 +1,141 @@
+// Package partitions mantém as partições mensais das tabelas nfe e
+// nfe_item, particionadas nativamente por RANGE (emissao) desde a migration
+// 0004_partition_nfe_by_emissao. Uma partição ausente para uma data fora do
+// horizonte já criado faz as linhas caírem na partição DEFAULT (nfe_default
+// / nfe_item_default) em vez de falhar o INSERT, mas isso degrada a poda por
+// particionamento — por isso o worker roda EnsurePartitions periodicamente
+// com alguns meses de antecedência (ver internal/worker).
+package partitions
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// partitionedTables são as tabelas particionadas por RANGE (emissao) cujas
+// partições mensais o EnsurePartitions mantém em dia.
+var partitionedTables = []string{"nfe", "nfe_item"}
+
+// EnsurePartitions garante que existam partições mensais de nfe e nfe_item
+// cobrindo cada mês entre from e to (inclusive), criando as que faltarem.
+// Idempotente: meses cuja partição já está anexada são ignorados (ver
+// partitionAttached).
+func EnsurePartitions(db *sql.DB, from, to time.Time) error {
+	start := firstOfMonth(from)
+	end := firstOfMonth(to)
+
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		for _, table := range partitionedTables {
+			if err := ensureMonthPartition(db, table, month); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func firstOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ensureMonthPartition cria a partição de table (nfe ou nfe_item) para o mês
+// de month, nomeada table_yYYYYmMM, cobrindo [month, próximo mês). Idempotente
+// via partitionAttached: se a partição já existir, não faz nada.
+func ensureMonthPartition(db *sql.DB, table string, month time.Time) error {
+	next := month.AddDate(0, 1, 0)
+	partName := fmt.Sprintf("%s_y%04dm%02d", table, month.Year(), int(month.Month()))
+
+	attached, err := partitionAttached(db, table, partName)
+	if err != nil {
+		return err
+	}
+	if attached {
+		return nil
+	}
+
+	return createMonthPartition(db, table, partName, month, next)
+}
+
+// createMonthPartition cria a partição mensal partName de table cobrindo
+// [month, next). A migration 0004 despeja todos os dados migrados na
+// partição DEFAULT (table_default), e Postgres recusa um
+// ATTACH/CREATE ... PARTITION OF ... FOR VALUES se a DEFAULT já contiver
+// alguma linha dentro do novo range — então, antes de anexar a partição
+// mensal, destacamos a DEFAULT, movemos só as linhas do mês para uma tabela
+// solta com a mesma forma (LIKE ... INCLUDING ALL), anexamos essa tabela
+// como a nova partição mensal e recolocamos a DEFAULT no lugar. Tudo numa
+// única transação sob ACCESS EXCLUSIVE, pra nenhuma escrita concorrente cair
+// na janela entre o DETACH e o ATTACH.
+func createMonthPartition(db *sql.DB, table, partName string, month, next time.Time) (err error) {
+	defaultPart := table + "_default"
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro iniciando transação para partição %s de %s: %w", partName, table, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(fmt.Sprintf(`LOCK TABLE %s, %s IN ACCESS EXCLUSIVE MODE`, table, defaultPart)); err != nil {
+		return fmt.Errorf("erro travando %s/%s para manutenção de partição: %w", table, defaultPart, err)
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s DETACH PARTITION %s`, table, defaultPart)); err != nil {
+		return fmt.Errorf("erro destacando partição default de %s: %w", table, err)
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING ALL)`, partName, table)); err != nil {
+		return fmt.Errorf("erro criando tabela solta para partição %s de %s: %w", partName, table, err)
+	}
+
+	moveSQL := fmt.Sprintf(
+		`WITH moved AS (DELETE FROM %s WHERE emissao >= $1 AND emissao < $2 RETURNING *) INSERT INTO %s SELECT * FROM moved`,
+		defaultPart, partName,
+	)
+	if _, err = tx.Exec(moveSQL, month, next); err != nil {
+		return fmt.Errorf("erro movendo linhas de %s para %s: %w", defaultPart, partName, err)
+	}
+
+	attachSQL := fmt.Sprintf(
+		`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+		table, partName, month.Format("2006-01-02"), next.Format("2006-01-02"),
+	)
+	if _, err = tx.Exec(attachSQL); err != nil {
+		return fmt.Errorf("erro anexando partição %s de %s: %w", partName, table, err)
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s DEFAULT`, table, defaultPart)); err != nil {
+		return fmt.Errorf("erro reanexando partição default de %s: %w", table, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("erro no commit da manutenção de partição %s de %s: %w", partName, table, err)
+	}
+
+	return nil
+}
+
+// partitionAttached reporta se child já é uma partição de parent (via
+// pg_inherits), pra EnsurePartitions ser idempotente sem precisar tentar o
+// CREATE/ATTACH e interpretar o erro de "já existe".
+func partitionAttached(db *sql.DB, parent, child string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_inherits i
+			JOIN pg_class c ON c.oid = i.inhrelid
+			JOIN pg_class p ON p.oid = i.inhparent
+			WHERE p.relname = $1 AND c.relname = $2
+		)
+	`, parent, child).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("erro verificando se %s já é partição de %s: %w", child, parent, err)
+	}
+	return exists, nil
+}
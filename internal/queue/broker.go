@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Broker é a abstração de fila usada pelo resto do módulo (watcher, worker,
+// admin): publicar jobs, consumir um a um ou em lote, inspecionar
+// profundidade das filas e fechar a conexão. watcher.New/worker.New
+// escolhem a implementação concreta via Open, então nenhum dos dois
+// precisa saber se por trás tem um RabbitMQ, um canal Go em memória ou um
+// JetStream do NATS.
+type Broker interface {
+	PublishJob(ctx context.Context, job Job) error
+	ConsumeJobs(ctx context.Context, handler func(Job) error) error
+	ConsumeJobsBatch(ctx context.Context, batchSize int, batchTimeout time.Duration, handler func([]Job) []error) error
+	Stats() ([]QueueDepth, error)
+
+	// SetDeadLetterHook registra fn pra ser chamado antes do Ack final de um
+	// job que esgotou as tentativas (ou foi marcado NonRetryable) — todas as
+	// implementações replicam esse ponto de extensão, usado pelo worker pra
+	// mover o arquivo original pra FailedDir com um sidecar de erro.
+	SetDeadLetterHook(fn func(job Job, retries int, lastErr error))
+
+	Close() error
+}
+
+var (
+	_ Broker = (*RabbitMQ)(nil)
+	_ Broker = (*MemBroker)(nil)
+	_ Broker = (*NATSBroker)(nil)
+)
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"nfe-drop/internal/metrics"
+)
+
+// MemBroker é um Broker inteiro em processo, sem dependência externa —
+// pensado pra testes e deployments single-process. Replica a semântica de
+// retry-com-backoff + DLQ do RabbitMQ (ver ackOrRetry em rabbitmq.go), só
+// que com goroutines e time.AfterFunc em vez de filas com x-message-ttl:
+// não há persistência, um processo que morre com jobs em voo perde esses
+// jobs, o mesmo trade-off que já existe hoje no modo polling sem fila.
+type MemBroker struct {
+	name string
+
+	jobs chan memEnvelope
+
+	mu             sync.Mutex
+	dlq            []memEnvelope
+	deadLetterHook func(job Job, retries int, lastErr error)
+
+	retryBase  time.Duration
+	retryMax   time.Duration
+	maxRetries int
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type memEnvelope struct {
+	job     Job
+	retries int
+}
+
+// memRegistry garante que dois Open("mem://nfe-drop-jobs", "nfe-drop-jobs")
+// no mesmo processo enxerguem a mesma fila em vez de duas isoladas — é
+// assim que watcher e worker conseguem trocar jobs num deployment
+// single-process (ex: testes de integração que sobem os dois na mesma
+// goroutine de teste).
+var memRegistry sync.Map // name string -> *MemBroker
+
+// NewMemBroker devolve o MemBroker registrado pra name, criando um na
+// primeira chamada.
+func NewMemBroker(name string) *MemBroker {
+	if v, ok := memRegistry.Load(name); ok {
+		return v.(*MemBroker)
+	}
+
+	maxRetries := 5
+	if v := os.Getenv("NFE_DROP_MAX_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxRetries = n
+		}
+	}
+
+	retryBase := 5 * time.Second
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_BASE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryBase = time.Duration(n) * time.Millisecond
+		}
+	}
+	retryMax := 10 * time.Minute
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_MAX_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryMax = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	b := &MemBroker{
+		name:       name,
+		jobs:       make(chan memEnvelope, 1024),
+		retryBase:  retryBase,
+		retryMax:   retryMax,
+		maxRetries: maxRetries,
+		closed:     make(chan struct{}),
+	}
+
+	actual, _ := memRegistry.LoadOrStore(name, b)
+	return actual.(*MemBroker)
+}
+
+func (b *MemBroker) PublishJob(ctx context.Context, job Job) error {
+	return b.enqueue(ctx, memEnvelope{job: job})
+}
+
+func (b *MemBroker) enqueue(ctx context.Context, env memEnvelope) error {
+	select {
+	case b.jobs <- env:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.closed:
+		return fmt.Errorf("mem broker %q fechado", b.name)
+	}
+}
+
+func (b *MemBroker) ConsumeJobs(ctx context.Context, handler func(Job) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.closed:
+			return fmt.Errorf("mem broker %q fechado", b.name)
+		case env := <-b.jobs:
+			b.handleResult(env, handler(env.job))
+		}
+	}
+}
+
+// ConsumeJobsBatch agrupa jobs em lotes de até batchSize (ou menos, se
+// batchTimeout expirar antes de encher o lote) — mesma política de
+// RabbitMQ.ConsumeJobsBatch, só que lendo do canal Go em vez do AMQP.
+func (b *MemBroker) ConsumeJobsBatch(ctx context.Context, batchSize int, batchTimeout time.Duration, handler func([]Job) []error) error {
+	for {
+		envs, err := b.collectBatch(ctx, batchSize, batchTimeout)
+		if len(envs) == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		jobs := make([]Job, len(envs))
+		for i, e := range envs {
+			jobs[i] = e.job
+		}
+
+		errs := handler(jobs)
+		if len(errs) != len(jobs) {
+			errs = make([]error, len(jobs))
+		}
+		for i, env := range envs {
+			b.handleResult(env, errs[i])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *MemBroker) collectBatch(ctx context.Context, batchSize int, timeout time.Duration) ([]memEnvelope, error) {
+	var envs []memEnvelope
+
+	select {
+	case <-ctx.Done():
+		return envs, ctx.Err()
+	case <-b.closed:
+		return envs, fmt.Errorf("mem broker %q fechado", b.name)
+	case env := <-b.jobs:
+		envs = append(envs, env)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for len(envs) < batchSize {
+		select {
+		case <-ctx.Done():
+			return envs, ctx.Err()
+		case <-b.closed:
+			return envs, fmt.Errorf("mem broker %q fechado", b.name)
+		case <-deadline.C:
+			return envs, nil
+		case env := <-b.jobs:
+			envs = append(envs, env)
+		}
+	}
+
+	return envs, nil
+}
+
+// handleResult decide o destino de um job já processado: descarta em caso
+// de sucesso, reagenda com backoff (via time.AfterFunc, já que não há
+// queue.TTL aqui) se ainda houver tentativas e o erro não for
+// NonRetryable, ou move pra DLQ em memória caso contrário.
+func (b *MemBroker) handleResult(env memEnvelope, err error) {
+	if err == nil {
+		return
+	}
+
+	var nonRetryable *NonRetryableError
+	if !errors.As(err, &nonRetryable) && env.retries < b.maxRetries {
+		delay := computeBackoffDelay(env.retries, b.retryBase, b.retryMax)
+		next := memEnvelope{job: env.job, retries: env.retries + 1}
+		metrics.ObserveJobRetry(b.name)
+		time.AfterFunc(delay, func() {
+			_ = b.enqueue(context.Background(), next)
+		})
+		return
+	}
+
+	b.mu.Lock()
+	b.dlq = append(b.dlq, env)
+	hook := b.deadLetterHook
+	b.mu.Unlock()
+
+	metrics.ObserveJobDeadLettered(b.name)
+	if hook != nil {
+		hook(env.job, env.retries, err)
+	}
+}
+
+// Stats devolve a profundidade da fila principal (mensagens no canal, não
+// as que estão dormindo num time.AfterFunc de retry) e da DLQ em memória.
+func (b *MemBroker) Stats() ([]QueueDepth, error) {
+	b.mu.Lock()
+	dlqDepth := len(b.dlq)
+	b.mu.Unlock()
+
+	return []QueueDepth{
+		{Queue: b.name, Messages: len(b.jobs)},
+		{Queue: b.name + ".dlq", Messages: dlqDepth},
+	}, nil
+}
+
+func (b *MemBroker) SetDeadLetterHook(fn func(job Job, retries int, lastErr error)) {
+	b.mu.Lock()
+	b.deadLetterHook = fn
+	b.mu.Unlock()
+}
+
+func (b *MemBroker) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		memRegistry.Delete(b.name)
+	})
+	return nil
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"nfe-drop/internal/metrics"
+)
+
+// NATSBroker implementa Broker sobre NATS JetStream: um stream durável
+// cobrindo o subject <name>, um consumer pull durável pra ConsumeJobs/
+// ConsumeJobsBatch, e um subject <name>.dlq pra onde vão os jobs que
+// esgotaram as tentativas. Ao contrário do RabbitMQ (que usa filas com TTL
+// + DLX pra atrasar redelivery, ver retryQueueTiers em rabbitmq.go), o
+// JetStream tem redelivery com atraso nativo via Nak(delay), então não
+// precisamos de filas-degrau — um único consumer cobre todos os retries.
+//
+// Nota de honestidade: este sandbox não tem go.mod/acesso de rede pra
+// baixar github.com/nats-io/nats.go e compilar de verdade. O código abaixo
+// segue a API documentada do cliente (JetStream, PullSubscribe, Fetch,
+// Nak/NakWithDelay) o mais fielmente possível, mas não foi compilado nem
+// testado contra um servidor real.
+type NATSBroker struct {
+	name    string
+	subject string
+	dlqSubj string
+
+	nc  *nats.Conn
+	js  nats.JetStreamContext
+	sub *nats.Subscription
+
+	mu             sync.Mutex
+	deadLetterHook func(job Job, retries int, lastErr error)
+
+	retryBase  time.Duration
+	retryMax   time.Duration
+	maxRetries int
+}
+
+// NewNATSBroker conecta em url, garante a existência do stream e do
+// consumer pull durável pra name, e devolve um NATSBroker pronto pra
+// publicar/consumir. name vira o nome do stream e o subject principal; o
+// consumer durável se chama sempre "nfe-drop-worker", qualquer que seja name.
+func NewNATSBroker(url, name string) (*NATSBroker, error) {
+	nc, err := nats.Connect(url, nats.Name("nfe-drop"))
+	if err != nil {
+		return nil, fmt.Errorf("erro conectando ao NATS: %w", err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("erro abrindo contexto JetStream: %w", err)
+	}
+
+	dlqSubj := name + ".dlq"
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: []string{name, dlqSubj},
+		Storage:  nats.FileStorage,
+	})
+	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		nc.Close()
+		return nil, fmt.Errorf("erro criando stream %q: %w", name, err)
+	}
+
+	durable := "nfe-drop-worker"
+	sub, err := js.PullSubscribe(name, durable, nats.AckWait(30*time.Second))
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("erro criando consumer pull %q: %w", durable, err)
+	}
+
+	maxRetries := 5
+	if v := os.Getenv("NFE_DROP_MAX_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxRetries = n
+		}
+	}
+	retryBase := 5 * time.Second
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_BASE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryBase = time.Duration(n) * time.Millisecond
+		}
+	}
+	retryMax := 10 * time.Minute
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_MAX_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryMax = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	return &NATSBroker{
+		name:       name,
+		subject:    name,
+		dlqSubj:    dlqSubj,
+		nc:         nc,
+		js:         js,
+		sub:        sub,
+		retryBase:  retryBase,
+		retryMax:   retryMax,
+		maxRetries: maxRetries,
+	}, nil
+}
+
+func (b *NATSBroker) PublishJob(ctx context.Context, job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("erro serializando job: %w", err)
+	}
+	if _, err := b.js.Publish(b.subject, body, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("erro publicando job no NATS: %w", err)
+	}
+	return nil
+}
+
+func (b *NATSBroker) ConsumeJobs(ctx context.Context, handler func(Job) error) error {
+	for {
+		msgs, err := b.sub.Fetch(1, nats.Context(ctx))
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			if errors.Is(err, nats.ErrTimeout) {
+				continue
+			}
+			return fmt.Errorf("erro buscando job do NATS: %w", err)
+		}
+
+		for _, msg := range msgs {
+			var job Job
+			if unmarshalErr := json.Unmarshal(msg.Data, &job); unmarshalErr != nil {
+				b.handleResult(msg, job, 0, unmarshalErr)
+				continue
+			}
+			retries := deliveriesOf(msg)
+			b.handleResult(msg, job, retries, handler(job))
+		}
+	}
+}
+
+// ConsumeJobsBatch busca até batchSize jobs de uma vez (bloqueando até
+// batchTimeout pra encher o lote), processa com handler e confirma cada
+// mensagem individualmente conforme o erro correspondente.
+func (b *NATSBroker) ConsumeJobsBatch(ctx context.Context, batchSize int, batchTimeout time.Duration, handler func([]Job) []error) error {
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, batchTimeout)
+		msgs, err := b.sub.Fetch(batchSize, nats.Context(fetchCtx))
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+				if len(msgs) == 0 {
+					continue
+				}
+			} else {
+				return fmt.Errorf("erro buscando lote do NATS: %w", err)
+			}
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		jobs := make([]Job, len(msgs))
+		retries := make([]int, len(msgs))
+		for i, msg := range msgs {
+			var job Job
+			unmarshalErr := json.Unmarshal(msg.Data, &job)
+			jobs[i] = job
+			retries[i] = deliveriesOf(msg)
+			if unmarshalErr != nil {
+				b.handleResult(msg, job, retries[i], unmarshalErr)
+			}
+		}
+
+		errs := handler(jobs)
+		if len(errs) != len(jobs) {
+			errs = make([]error, len(jobs))
+		}
+		for i, msg := range msgs {
+			b.handleResult(msg, jobs[i], retries[i], errs[i])
+		}
+	}
+}
+
+// handleResult confirma (Ack) um job bem-sucedido, reenvia com atraso via
+// NakWithDelay se ainda houver tentativas e o erro não for NonRetryable, ou
+// publica no subject de dead-letter e dá Ack caso contrário.
+func (b *NATSBroker) handleResult(msg *nats.Msg, job Job, retries int, err error) {
+	if err == nil {
+		_ = msg.Ack()
+		return
+	}
+
+	var nonRetryable *NonRetryableError
+	if !errors.As(err, &nonRetryable) && retries < b.maxRetries {
+		delay := computeBackoffDelay(retries, b.retryBase, b.retryMax)
+		metrics.ObserveJobRetry(b.name)
+		_ = msg.NakWithDelay(delay)
+		return
+	}
+
+	if body, marshalErr := json.Marshal(job); marshalErr == nil {
+		_, _ = b.js.Publish(b.dlqSubj, body)
+	}
+
+	metrics.ObserveJobDeadLettered(b.name)
+	b.mu.Lock()
+	hook := b.deadLetterHook
+	b.mu.Unlock()
+	if hook != nil {
+		hook(job, retries, err)
+	}
+	_ = msg.Ack()
+}
+
+// Stats devolve a profundidade do stream principal (que cobre tanto o
+// subject de jobs quanto o de dead-letter, já que ambos vivem no mesmo
+// stream) via StreamInfo, reportada como duas entradas pra espelhar o
+// formato do RabbitMQ.Stats.
+func (b *NATSBroker) Stats() ([]QueueDepth, error) {
+	info, err := b.js.StreamInfo(b.name)
+	if err != nil {
+		return nil, fmt.Errorf("erro consultando stream %q: %w", b.name, err)
+	}
+
+	consumerInfo, err := b.sub.ConsumerInfo()
+	if err != nil {
+		return nil, fmt.Errorf("erro consultando consumer: %w", err)
+	}
+
+	return []QueueDepth{
+		{Queue: b.subject, Messages: int(consumerInfo.NumPending)},
+		{Queue: b.dlqSubj, Messages: int(info.State.Msgs) - int(consumerInfo.NumPending)},
+	}, nil
+}
+
+func (b *NATSBroker) SetDeadLetterHook(fn func(job Job, retries int, lastErr error)) {
+	b.mu.Lock()
+	b.deadLetterHook = fn
+	b.mu.Unlock()
+}
+
+func (b *NATSBroker) Close() error {
+	b.nc.Close()
+	return nil
+}
+
+// deliveriesOf devolve quantas vezes msg já foi entregue, convertida pro
+// mesmo "attempt" zero-based usado por computeBackoffDelay (primeira
+// entrega = 0 tentativas anteriores).
+func deliveriesOf(msg *nats.Msg) int {
+	meta, err := msg.Metadata()
+	if err != nil || meta.NumDelivered == 0 {
+		return 0
+	}
+	return int(meta.NumDelivered) - 1
+}
@@ -3,13 +3,19 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+
+	"nfe-drop/internal/logx"
+	"nfe-drop/internal/metrics"
 )
 
 type Job struct {
@@ -18,46 +24,201 @@ type Job struct {
 	Kind     string `json:"kind"` // "xml" ou "zip"
 }
 
+// retryTier é um degrau da cadeia de retry com backoff exponencial: cada fila
+// tem um x-message-ttl próprio e, ao expirar, a mensagem volta pra fila
+// principal via DLX no exchange padrão (sem precisar do plugin
+// x-delayed-message).
+type retryTier struct {
+	queueName string
+	delay     time.Duration
+}
+
+// NonRetryableError marca um erro do handler como definitivo: o job vai
+// direto pra dead-letter queue sem passar pelos degraus de retry. Use
+// NonRetryable(err) pra envelopar erros de parse (XML malformado não vira
+// válido de novo só porque tentamos de novo).
+type NonRetryableError struct {
+	err error
+}
+
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &NonRetryableError{err: err}
+}
+
+func (e *NonRetryableError) Error() string { return e.err.Error() }
+func (e *NonRetryableError) Unwrap() error { return e.err }
+
+// RabbitMQ é um Broker com reconexão supervisionada: uma conexão/canal que
+// cai (restart do broker, rede instável) não derruba o processo — redial
+// roda em background com backoff exponencial, re-declarando DLX/DLQ/fila
+// principal/degraus de retry e reabilitando publisher confirms, enquanto
+// PublishJob/ConsumeJobs/ConsumeJobsBatch esperam a reconexão em vez de
+// retornar erro fatal. conn/ch só devem ser lidos através de waitReady (ou
+// sob mu, como em Close), nunca diretamente, já que são substituídos a cada
+// reconexão.
 type RabbitMQ struct {
-	conn       *amqp.Connection
-	ch         *amqp.Channel
-	queueName  string
-	confirmCh  <-chan amqp.Confirmation
-	maxRetries int
-	prefetch   int
+	url       string
+	queueName string
+	dlqName   string
+
+	maxRetries      int
+	prefetch        int
+	concurrency     int
+	retryTiers      []retryTier
+	retryBase       time.Duration
+	retryMax        time.Duration
+	publishBlocking bool
+
+	mu      sync.RWMutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	ready   bool
+	readyCh chan struct{} // fechado quando ready vira true; trocado por um novo a cada queda
+	connErr error
+	connGen uint64
+
+	// publishMu serializa a alocação da sequence tag (GetNextPublishSeqNo)
+	// com a chamada de Publish correspondente, pra garantir que a tag
+	// registrada em pending seja exatamente a que o broker vai confirmar —
+	// sem isso, duas goroutines publicando ao mesmo tempo poderiam registrar
+	// a tag errada uma pra outra. A espera pela confirmação em si acontece
+	// fora dessa seção crítica, então publishes continuam pipelinados.
+	publishMu sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   map[uint64]chan error
+
+	// inflight limita quantos publishes podem estar aguardando confirmação
+	// ao mesmo tempo (NFE_DROP_RABBITMQ_INFLIGHT) — sem isso um produtor
+	// rápido poderia empilhar um `pending` sem limite enquanto o broker
+	// ainda não confirmou nada.
+	inflight chan struct{}
+
+	closeOnce sync.Once
+	stopCh    chan struct{}
+
+	// DeadLetterHook, se definido, é chamado antes do Ack de um job que
+	// esgotou as tentativas (ou que foi marcado como NonRetryable), pra que
+	// o caller possa mover o arquivo original pra FailedDir e gravar um
+	// sidecar de erro.
+	DeadLetterHook func(job Job, retries int, lastErr error)
 }
 
 func NewRabbitMQ(url, queueName string) (*RabbitMQ, error) {
 	// defaults
-	maxRetries := 3
-	prefetch := 10
-
+	maxRetries := 5
+	if v := os.Getenv("NFE_DROP_MAX_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxRetries = n
+		}
+	}
+	// NFE_DROP_RABBITMQ_MAX_RETRIES é o nome histórico da variável; se
+	// definida, ela prevalece sobre NFE_DROP_MAX_RETRIES.
 	if v := os.Getenv("NFE_DROP_RABBITMQ_MAX_RETRIES"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			maxRetries = n
 		}
 	}
 
+	prefetch := 10
 	if v := os.Getenv("NFE_DROP_RABBITMQ_PREFETCH"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			prefetch = n
 		}
 	}
 
-	conn, err := amqp.Dial(url)
+	inflightLimit := 64
+	if v := os.Getenv("NFE_DROP_RABBITMQ_INFLIGHT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			inflightLimit = n
+		}
+	}
+
+	// NFE_DROP_RABBITMQ_CONCURRENCY (padrão 1, ou seja, comportamento
+	// anterior de um delivery por vez) é quantas goroutines ConsumeJobs/
+	// ConsumeJobsBatch mantêm lendo de `msgs` em paralelo e chamando
+	// handler — vale a pena subir isso perto de prefetch pra aproveitar a
+	// janela de QoS em vez de deixar mensagens já entregues esperando uma
+	// goroutine única processar a anterior.
+	concurrency := 1
+	if v := os.Getenv("NFE_DROP_RABBITMQ_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			concurrency = n
+		}
+	}
+
+	retryBase := 5 * time.Second
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_BASE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryBase = time.Duration(n) * time.Millisecond
+		}
+	}
+	retryMax := 10 * time.Minute
+	if v := os.Getenv("NFE_DROP_RABBITMQ_RETRY_MAX_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			retryMax = time.Duration(n) * time.Millisecond
+		}
+	}
+	if retryMax < retryBase {
+		retryMax = retryBase
+	}
+
+	// NFE_DROP_RABBITMQ_PUBLISH_BLOCKING (padrão true) decide o que
+	// PublishJob faz com a conexão caída: esperar a reconexão (bloqueante,
+	// padrão) ou falhar na hora (pra callers que preferem tratar a fila
+	// indisponível como erro imediato em vez de pendurar a requisição).
+	publishBlocking := true
+	if v := os.Getenv("NFE_DROP_RABBITMQ_PUBLISH_BLOCKING"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			publishBlocking = b
+		}
+	}
+
+	r := &RabbitMQ{
+		url:             url,
+		queueName:       queueName,
+		dlqName:         queueName + ".dlq",
+		maxRetries:      maxRetries,
+		prefetch:        prefetch,
+		concurrency:     concurrency,
+		retryTiers:      retryQueueTiers(queueName, retryBase, retryMax),
+		retryBase:       retryBase,
+		retryMax:        retryMax,
+		publishBlocking: publishBlocking,
+		readyCh:         make(chan struct{}),
+		pending:         make(map[uint64]chan error),
+		inflight:        make(chan struct{}, inflightLimit),
+		stopCh:          make(chan struct{}),
+	}
+
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// connect abre uma conexão/canal novos, declara toda a topologia (DLX, DLQ,
+// fila principal, degraus de retry), habilita QoS e publisher confirms, e
+// publica o resultado nos campos protegidos por mu — acordando quem estava
+// esperando em waitReady. É chamado uma vez por NewRabbitMQ e de novo, a
+// cada tentativa, por redialLoop.
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(r.url)
 	if err != nil {
-		return nil, fmt.Errorf("erro conectando no RabbitMQ: %w", err)
+		return fmt.Errorf("erro conectando no RabbitMQ: %w", err)
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("erro abrindo canal no RabbitMQ: %w", err)
+		return fmt.Errorf("erro abrindo canal no RabbitMQ: %w", err)
 	}
 
-	// DLX + DLQ
-	dlxName := queueName + ".dlx"
-	dlqName := queueName + ".dlq"
+	dlxName := r.queueName + ".dlx"
 
 	if err := ch.ExchangeDeclare(
 		dlxName,
@@ -70,11 +231,11 @@ func NewRabbitMQ(url, queueName string) (*RabbitMQ, error) {
 	); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro declarando exchange DLX %q: %w", dlxName, err)
+		return fmt.Errorf("erro declarando exchange DLX %q: %w", dlxName, err)
 	}
 
 	if _, err := ch.QueueDeclare(
-		dlqName,
+		r.dlqName,
 		true,  // durable
 		false, // autoDelete
 		false, // exclusive
@@ -83,29 +244,30 @@ func NewRabbitMQ(url, queueName string) (*RabbitMQ, error) {
 	); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro declarando fila DLQ %q: %w", dlqName, err)
+		return fmt.Errorf("erro declarando fila DLQ %q: %w", r.dlqName, err)
 	}
 
 	if err := ch.QueueBind(
-		dlqName,
-		dlqName,
+		r.dlqName,
+		r.dlqName,
 		dlxName,
 		false,
 		nil,
 	); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro bindando DLQ %q no DLX %q: %w", dlqName, dlxName, err)
+		return fmt.Errorf("erro bindando DLQ %q no DLX %q: %w", r.dlqName, dlxName, err)
 	}
 
-	// fila principal com DLX configurado
+	// fila principal com DLX configurado (usado só como rede de segurança;
+	// o caminho normal de retry/dead-letter é explícito em ConsumeJobs)
 	args := amqp.Table{
 		"x-dead-letter-exchange":    dlxName,
-		"x-dead-letter-routing-key": dlqName,
+		"x-dead-letter-routing-key": r.dlqName,
 	}
 
 	if _, err := ch.QueueDeclare(
-		queueName,
+		r.queueName,
 		true,  // durable
 		false, // autoDelete
 		false, // exclusive
@@ -114,36 +276,296 @@ func NewRabbitMQ(url, queueName string) (*RabbitMQ, error) {
 	); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro declarando fila %q: %w", queueName, err)
+		return fmt.Errorf("erro declarando fila %q: %w", r.queueName, err)
+	}
+
+	// cadeia de filas de retry com backoff exponencial, derivada de
+	// retryBase/retryMax (NFE_DROP_RABBITMQ_RETRY_BASE_MS/_MAX_MS): cada
+	// degrau quadruplica o delay do anterior até estourar retryMax, que vira
+	// o último degrau. Cada fila devolve a mensagem pra fila principal (via
+	// exchange padrão, que em AMQP é representado por "") quando o TTL
+	// expira — ver retryQueueTiers.
+	for _, tier := range r.retryTiers {
+		tierArgs := amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": r.queueName,
+			"x-message-ttl":             int64(tier.delay / time.Millisecond),
+		}
+		if _, err := ch.QueueDeclare(
+			tier.queueName,
+			true,  // durable
+			false, // autoDelete
+			false, // exclusive
+			false, // noWait
+			tierArgs,
+		); err != nil {
+			ch.Close()
+			conn.Close()
+			return fmt.Errorf("erro declarando fila de retry %q: %w", tier.queueName, err)
+		}
 	}
 
 	// QoS (prefetch)
-	if err := ch.Qos(prefetch, 0, false); err != nil {
+	if err := ch.Qos(r.prefetch, 0, false); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro configurando QoS (prefetch=%d): %w", prefetch, err)
+		return fmt.Errorf("erro configurando QoS (prefetch=%d): %w", r.prefetch, err)
 	}
 
 	// publisher confirms
 	if err := ch.Confirm(false); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("erro habilitando publisher confirms: %w", err)
+		return fmt.Errorf("erro habilitando publisher confirms: %w", err)
+	}
+
+	confirmCh := ch.NotifyPublish(make(chan amqp.Confirmation, cap(r.inflight)))
+	returnCh := ch.NotifyReturn(make(chan amqp.Return, cap(r.inflight)))
+	closeCh := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.mu.Lock()
+	r.conn = conn
+	r.ch = ch
+	r.ready = true
+	r.connErr = nil
+	r.connGen++
+	gen := r.connGen
+	close(r.readyCh)
+	r.readyCh = make(chan struct{})
+	r.mu.Unlock()
+
+	go r.supervise(gen, confirmCh, returnCh, closeCh)
+
+	return nil
+}
+
+// supervise drena confirmCh/returnCh/closeCh de uma geração de conexão em
+// background, resolvendo o pending[seq] correspondente a cada confirmação.
+// Se o canal cair (ou o broker fechar a conexão), dispara handleDisconnect
+// pra essa geração e encerra — uma reconexão bem-sucedida já terá iniciado
+// sua própria supervise antes que essa goroutine precise fazer algo mais.
+func (r *RabbitMQ) supervise(gen uint64, confirmCh <-chan amqp.Confirmation, returnCh <-chan amqp.Return, closeCh <-chan *amqp.Error) {
+	for {
+		select {
+		case conf, ok := <-confirmCh:
+			if !ok {
+				r.handleDisconnect(gen, fmt.Errorf("canal de confirmações do RabbitMQ fechado"))
+				return
+			}
+			r.resolvePending(conf.DeliveryTag, conf.Ack)
+
+		case ret, ok := <-returnCh:
+			if !ok {
+				continue
+			}
+			slog.Warn("mensagem devolvida pelo broker (unroutable)",
+				"exchange", ret.Exchange,
+				"routing_key", ret.RoutingKey,
+				"reply_text", ret.ReplyText,
+			)
+
+		case cerr, ok := <-closeCh:
+			if !ok {
+				return
+			}
+			r.handleDisconnect(gen, fmt.Errorf("canal RabbitMQ fechado: %v", cerr))
+			return
+
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// handleDisconnect marca a conexão como indisponível e dispara redialLoop,
+// a menos que gen já tenha sido superada por uma reconexão mais nova (nesse
+// caso essa goroutine supervise está encerrando tarde e não há nada a fazer).
+func (r *RabbitMQ) handleDisconnect(gen uint64, cause error) {
+	r.mu.Lock()
+	if gen != r.connGen {
+		r.mu.Unlock()
+		return
 	}
+	r.ready = false
+	r.connErr = cause
+	r.mu.Unlock()
 
-	confirmCh := ch.NotifyPublish(make(chan amqp.Confirmation, prefetch*2))
+	r.failAllPending(cause)
 
-	return &RabbitMQ{
-		conn:       conn,
-		ch:         ch,
-		queueName:  queueName,
-		confirmCh:  confirmCh,
-		maxRetries: maxRetries,
-		prefetch:   prefetch,
-	}, nil
+	select {
+	case <-r.stopCh:
+		return
+	default:
+	}
+
+	slog.Error("conexão RabbitMQ perdida; iniciando reconexão", "err", cause)
+	go r.redialLoop()
 }
 
-func (r *RabbitMQ) publish(ctx context.Context, body []byte, headers amqp.Table) error {
+// redialLoop tenta reconectar com backoff exponencial (1s, 2s, 4s... até um
+// teto de 30s) até conseguir ou até Close() ser chamado.
+func (r *RabbitMQ) redialLoop() {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		if err := r.connect(); err != nil {
+			slog.Error("falha ao reconectar no RabbitMQ; nova tentativa em breve",
+				"err", err,
+				"backoff", backoff,
+			)
+			select {
+			case <-time.After(backoff):
+			case <-r.stopCh:
+				return
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		slog.Info("reconexão com o RabbitMQ bem-sucedida")
+		return
+	}
+}
+
+// waitReady devolve o canal AMQP atual assim que (re)conectado. Se a conexão
+// estiver caída: com blocking=true espera até reconectar ou ctx encerrar
+// (usado por ConsumeJobs/ConsumeJobsBatch, que precisam sobreviver a
+// qualquer número de quedas); com blocking=false falha imediatamente (modo
+// usado por publishTo quando NFE_DROP_RABBITMQ_PUBLISH_BLOCKING=false).
+func (r *RabbitMQ) waitReady(ctx context.Context, blocking bool) (*amqp.Channel, error) {
+	for {
+		r.mu.RLock()
+		ready := r.ready
+		ch := r.ch
+		readyCh := r.readyCh
+		connErr := r.connErr
+		r.mu.RUnlock()
+
+		if ready {
+			return ch, nil
+		}
+
+		if !blocking {
+			if connErr == nil {
+				connErr = fmt.Errorf("conexão RabbitMQ indisponível")
+			}
+			return nil, connErr
+		}
+
+		select {
+		case <-readyCh:
+			continue
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.stopCh:
+			return nil, fmt.Errorf("RabbitMQ fechado")
+		}
+	}
+}
+
+func (r *RabbitMQ) resolvePending(tag uint64, ack bool) {
+	r.pendingMu.Lock()
+	errCh, ok := r.pending[tag]
+	delete(r.pending, tag)
+	r.pendingMu.Unlock()
+
+	if !ok {
+		return
+	}
+	if ack {
+		errCh <- nil
+	} else {
+		errCh <- fmt.Errorf("mensagem não confirmada pelo broker (delivery_tag=%d)", tag)
+	}
+}
+
+func (r *RabbitMQ) failAllPending(cause error) {
+	r.pendingMu.Lock()
+	pending := r.pending
+	r.pending = make(map[uint64]chan error)
+	r.pendingMu.Unlock()
+
+	for _, errCh := range pending {
+		errCh <- cause
+	}
+}
+
+// retryQueueTiers monta a cadeia de degraus de retry entre base e max:
+// cada degrau quadruplica o delay do anterior (ex: base=5s → 5s, 20s, 80s,
+// 320s) até que o próximo passo estouraria max, caso em que max vira o
+// último degrau exato. O nome da fila carrega o delay em ms pra ficar
+// legível em `rabbitmqctl list_queues` sem precisar consultar o código.
+func retryQueueTiers(queueName string, base, max time.Duration) []retryTier {
+	var tiers []retryTier
+	for d := base; d < max; d *= 4 {
+		tiers = append(tiers, retryTier{
+			queueName: fmt.Sprintf("%s.retry.%dms", queueName, d.Milliseconds()),
+			delay:     d,
+		})
+	}
+	tiers = append(tiers, retryTier{
+		queueName: fmt.Sprintf("%s.retry.%dms", queueName, max.Milliseconds()),
+		delay:     max,
+	})
+	return tiers
+}
+
+// computeBackoffDelay calcula o delay bruto (antes de escolher o degrau) da
+// tentativa attempt (0-based): base*2^attempt, com jitter de ±25% e limitado
+// a max. O jitter existe pra espalhar no tempo um lote de jobs que falhou
+// junto (ex: broker do SEFAZ fora do ar por 1 minuto não deveria fazer todo
+// mundo bater na fila principal de volta no mesmo instante).
+func computeBackoffDelay(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	if max <= 0 {
+		max = 10 * time.Minute
+	}
+
+	shift := attempt
+	if shift > 20 { // acima disso 1<<shift já estoura time.Duration (int64 de ns)
+		shift = 20
+	}
+	delay := base * time.Duration(int64(1)<<uint(shift))
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/2+1)) - delay/4
+	delay += jitter
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// publishTo publica `body` na fila `queueName` via exchange padrão,
+// aguardando a confirmação do broker. expiration, se > 0, vira o TTL
+// por-mensagem (propriedade AMQP `expiration`): usado pelos degraus de
+// retry pra aplicar jitter dentro do TTL do degrau escolhido, já que o
+// x-message-ttl da fila é fixo. expiration == 0 deixa a mensagem sujeita só
+// ao x-message-ttl da fila de destino (ou sem TTL nenhum, na fila
+// principal/DLQ).
+func (r *RabbitMQ) publishTo(ctx context.Context, queueName string, body []byte, headers amqp.Table, expiration time.Duration) error {
+	ch, err := r.waitReady(ctx, r.publishBlocking)
+	if err != nil {
+		return fmt.Errorf("erro publicando mensagem na fila %q: %w", queueName, err)
+	}
+
 	if headers == nil {
 		headers = amqp.Table{}
 	}
@@ -151,35 +573,58 @@ func (r *RabbitMQ) publish(ctx context.Context, body []byte, headers amqp.Table)
 		headers["x-retries"] = int32(0)
 	}
 
-	err := r.ch.PublishWithContext(
+	pub := amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Headers:      headers,
+	}
+	if expiration > 0 {
+		pub.Expiration = strconv.FormatInt(expiration.Milliseconds(), 10)
+	}
+
+	// Janela limitada de publishes em voo (NFE_DROP_RABBITMQ_INFLIGHT): sem
+	// isso um produtor rápido acumularia um pending sem limite enquanto
+	// espera confirmações do broker.
+	select {
+	case r.inflight <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-r.inflight }()
+
+	errCh := make(chan error, 1)
+
+	r.publishMu.Lock()
+	seq := ch.GetNextPublishSeqNo()
+	r.pendingMu.Lock()
+	r.pending[seq] = errCh
+	r.pendingMu.Unlock()
+
+	pubErr := ch.PublishWithContext(
 		ctx,
 		"", // exchange padrão
-		r.queueName,
+		queueName,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         body,
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-			Headers:      headers,
-		},
+		pub,
 	)
-	if err != nil {
-		return fmt.Errorf("erro publicando mensagem no RabbitMQ: %w", err)
+	r.publishMu.Unlock()
+
+	if pubErr != nil {
+		r.pendingMu.Lock()
+		delete(r.pending, seq)
+		r.pendingMu.Unlock()
+		return fmt.Errorf("erro publicando mensagem na fila %q: %w", queueName, pubErr)
 	}
 
-	// Espera confirmação do broker
 	select {
-	case conf := <-r.confirmCh:
-		if !conf.Ack {
-			return fmt.Errorf("mensagem não confirmada pelo broker")
-		}
+	case err := <-errCh:
+		return err
 	case <-ctx.Done():
 		return ctx.Err()
 	}
-
-	return nil
 }
 
 func (r *RabbitMQ) PublishJob(ctx context.Context, job Job) error {
@@ -188,25 +633,120 @@ func (r *RabbitMQ) PublishJob(ctx context.Context, job Job) error {
 		return fmt.Errorf("erro serializando job: %w", err)
 	}
 
-	return r.publish(ctx, body, amqp.Table{
+	return r.publishTo(ctx, r.queueName, body, amqp.Table{
 		"x-retries": int32(0),
-	})
+	}, 0)
 }
 
+// republishForRetry reenfileira `body` no degrau de retry cujo TTL é o
+// menor que ainda comporta o delay calculado pra tentativa `attempt`
+// (0-based): delay = retryBase*2^attempt com jitter, limitado a retryMax.
+// O jitter em si é aplicado como TTL por-mensagem (<= TTL do degrau), já
+// que o x-message-ttl da fila não varia por mensagem.
+func (r *RabbitMQ) republishForRetry(ctx context.Context, attempt int, body []byte, headers amqp.Table) error {
+	raw := computeBackoffDelay(attempt, r.retryBase, r.retryMax)
+
+	tier := r.retryTiers[len(r.retryTiers)-1]
+	for _, t := range r.retryTiers {
+		if t.delay >= raw {
+			tier = t
+			break
+		}
+	}
+
+	expiration := raw
+	if expiration > tier.delay {
+		expiration = tier.delay
+	}
+
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers["x-retries"] = int32(attempt + 1)
+
+	return r.publishTo(ctx, tier.queueName, body, headers, expiration)
+}
+
+// ConsumeJobs consome r.queueName um job por vez, sobrevivendo a qualquer
+// número de quedas de conexão: quando o canal cai, espera a reconexão
+// (disparada em background por handleDisconnect/redialLoop) e reinicia o
+// Consume no novo canal, sem retornar erro nem deixar jobs já entregues sem
+// ack — o broker redeixa pra entrega quem não foi confirmado antes da queda.
 func (r *RabbitMQ) ConsumeJobs(ctx context.Context, handler func(Job) error) error {
-	msgs, err := r.ch.Consume(
-		r.queueName,
-		"",
-		false, // autoAck
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("erro iniciando consumo do RabbitMQ: %w", err)
+	for {
+		ch, err := r.waitReady(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := ch.Consume(
+			r.queueName,
+			"",
+			false, // autoAck
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			slog.Error("erro iniciando consumo do RabbitMQ; nova tentativa após reconexão", "err", err)
+			continue
+		}
+
+		err = r.consumeLoop(ctx, msgs, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			slog.Warn("canal de consumo do RabbitMQ caiu; aguardando reconexão", "err", err)
+			continue
+		}
+		return nil
+	}
+}
+
+// consumeLoop mantém r.concurrency goroutines lendo de msgs em paralelo (ver
+// consumeWorker), cada uma responsável pelo ack/nack/retry da sua própria
+// entrega. O republish de retry (ackOrRetry → publishTo) já é seguro sob
+// concorrência desde o publishMu introduzido junto dos publisher confirms
+// (ver publishTo), então nenhum canal de publish dedicado por worker é
+// necessário. consumeLoop só retorna depois que todas as goroutines
+// terminarem: no encerramento via ctx cancelado, cada worker para de puxar
+// novas entregas mas completa o handler que já estiver em andamento antes
+// de sair, então nenhum job fica pela metade.
+func (r *RabbitMQ) consumeLoop(ctx context.Context, msgs <-chan amqp.Delivery, handler func(Job) error) error {
+	concurrency := r.concurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
 
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.consumeWorker(ctx, msgs, handler)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var firstErr error
+	for err := range errs {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// consumeWorker é uma goroutine do pool de consumeLoop: puxa entregas de
+// msgs até ctx ser cancelado ou o canal fechar (sinal de que o canal AMQP
+// caiu, tratado pelo loop de reconexão em ConsumeJobs).
+func (r *RabbitMQ) consumeWorker(ctx context.Context, msgs <-chan amqp.Delivery, handler func(Job) error) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -217,6 +757,8 @@ func (r *RabbitMQ) ConsumeJobs(ctx context.Context, handler func(Job) error) err
 				return fmt.Errorf("canal de mensagens encerrado")
 			}
 
+			logx.Trace("queue", "mensagem recebida do RabbitMQ", "queue", r.queueName, "retries", extractRetries(msg.Headers))
+
 			var job Job
 			if err := json.Unmarshal(msg.Body, &job); err != nil {
 				slog.Error("erro de unmarshal de job do RabbitMQ", "err", err)
@@ -224,60 +766,261 @@ func (r *RabbitMQ) ConsumeJobs(ctx context.Context, handler func(Job) error) err
 				continue
 			}
 
-			if err := handler(job); err != nil {
-				// erro do handler → retry ou DLQ
-				retries := extractRetries(msg.Headers)
-
-				if retries < r.maxRetries {
-					slog.Warn("erro processando job, reenfileirando",
-						"path", job.Path,
-						"filename", job.Filename,
-						"kind", job.Kind,
-						"retries", retries,
-						"max_retries", r.maxRetries,
-						"err", err,
-					)
-
-					headers := msg.Headers
-					if headers == nil {
-						headers = amqp.Table{}
-					}
-					headers["x-retries"] = int32(retries + 1)
-
-					pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-					if perr := r.publish(pubCtx, msg.Body, headers); perr != nil {
-						slog.Error("falha ao reenfileirar job", "err", perr)
-					}
-					cancel()
-
-					_ = msg.Ack(false)
-				} else {
-					slog.Error("erro processando job, enviando para DLQ",
-						"path", job.Path,
-						"filename", job.Filename,
-						"kind", job.Kind,
-						"retries", retries,
-						"max_retries", r.maxRetries,
-						"err", err,
-					)
-					// Nack sem requeue → vai pro DLQ por causa do DLX
-					_ = msg.Nack(false, false)
-				}
+			r.ackOrRetry(ctx, msg, job, handler(job))
+		}
+	}
+}
+
+// ackOrRetry decide o destino de uma mensagem já processada pelo handler:
+// Ack direto em caso de sucesso, reagendamento num degrau de retry com
+// backoff se ainda houver tentativas e o erro não for NonRetryable, ou
+// dead-letter caso contrário. Compartilhada por ConsumeJobs e
+// ConsumeJobsBatch pra manter a mesma política de retry/DLQ nos dois
+// caminhos.
+func (r *RabbitMQ) ackOrRetry(ctx context.Context, msg amqp.Delivery, job Job, err error) {
+	if err == nil {
+		_ = msg.Ack(false)
+		return
+	}
+
+	retries := extractRetries(msg.Headers)
+
+	var nonRetryable *NonRetryableError
+	if !errors.As(err, &nonRetryable) && retries < r.maxRetries {
+		slog.Warn("erro processando job, agendando nova tentativa com backoff",
+			"path", job.Path,
+			"filename", job.Filename,
+			"kind", job.Kind,
+			"retries", retries,
+			"max_retries", r.maxRetries,
+			"err", err,
+		)
+
+		headers := msg.Headers
+		pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		perr := r.republishForRetry(pubCtx, retries, msg.Body, headers)
+		cancel()
+		if perr != nil {
+			slog.Error("falha ao agendar retry do job", "err", perr)
+		}
+
+		metrics.ObserveJobRetry(r.queueName)
+		_ = msg.Ack(false)
+		return
+	}
+
+	slog.Error("erro processando job, enviando para dead-letter queue",
+		"path", job.Path,
+		"filename", job.Filename,
+		"kind", job.Kind,
+		"retries", retries,
+		"max_retries", r.maxRetries,
+		"non_retryable", nonRetryable != nil,
+		"err", err,
+	)
+
+	headers := msg.Headers
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers["x-retries"] = int32(retries)
+	headers["x-death-reason"] = err.Error()
+
+	deadCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	if perr := r.publishTo(deadCtx, r.dlqName, msg.Body, headers, 0); perr != nil {
+		slog.Error("falha ao publicar job na dead-letter queue", "err", perr)
+	}
+	cancel()
+
+	metrics.ObserveJobDeadLettered(r.queueName)
+	if r.DeadLetterHook != nil {
+		r.DeadLetterHook(job, retries, err)
+	}
+
+	_ = msg.Ack(false)
+}
+
+// collectBatch drena até batchSize mensagens de msgs, retornando mais cedo
+// (com o que já tiver sido coletado) se `timeout` se esgotar antes de
+// encher o lote. Usado por ConsumeJobsBatch pra não deixar o primeiro job
+// de um lote esperando indefinidamente pelos próximos.
+func collectBatch(ctx context.Context, msgs <-chan amqp.Delivery, batchSize int, timeout time.Duration) ([]Job, []amqp.Delivery, error) {
+	jobs := make([]Job, 0, batchSize)
+	deliveries := make([]amqp.Delivery, 0, batchSize)
+
+	// Espera a primeira mensagem sem prazo: não faz sentido acordar um
+	// worker ocioso periodicamente só pra não ter nada pra processar.
+	select {
+	case <-ctx.Done():
+		return jobs, deliveries, ctx.Err()
+	case msg, ok := <-msgs:
+		if !ok {
+			return jobs, deliveries, fmt.Errorf("canal de mensagens encerrado")
+		}
+		var job Job
+		if err := json.Unmarshal(msg.Body, &job); err != nil {
+			slog.Error("erro de unmarshal de job do RabbitMQ", "err", err)
+			_ = msg.Ack(false)
+		} else {
+			jobs = append(jobs, job)
+			deliveries = append(deliveries, msg)
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
 
+	for len(jobs) < batchSize {
+		select {
+		case <-ctx.Done():
+			return jobs, deliveries, ctx.Err()
+
+		case <-deadline.C:
+			return jobs, deliveries, nil
+
+		case msg, ok := <-msgs:
+			if !ok {
+				return jobs, deliveries, fmt.Errorf("canal de mensagens encerrado")
+			}
+			var job Job
+			if err := json.Unmarshal(msg.Body, &job); err != nil {
+				slog.Error("erro de unmarshal de job do RabbitMQ", "err", err)
+				_ = msg.Ack(false)
 				continue
 			}
+			jobs = append(jobs, job)
+			deliveries = append(deliveries, msg)
+		}
+	}
 
-			_ = msg.Ack(false)
+	return jobs, deliveries, nil
+}
+
+// ConsumeJobsBatch agrupa jobs em lotes de até batchSize (ou menos, se
+// batchTimeout expirar antes de encher o lote) e entrega todos de uma vez
+// pro handler, que devolve um []error alinhado posicionalmente com o lote
+// recebido. Pensado pro caminho de ingestão em massa via
+// storage.SaveNFeBatch, que só compensa operando em cima de vários
+// registros por transação. Sobrevive a quedas de conexão da mesma forma que
+// ConsumeJobs: espera reconectar e reinicia o Consume, em vez de propagar
+// erro fatal pro worker.
+func (r *RabbitMQ) ConsumeJobsBatch(ctx context.Context, batchSize int, batchTimeout time.Duration, handler func([]Job) []error) error {
+	for {
+		ch, err := r.waitReady(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := ch.Consume(
+			r.queueName,
+			"",
+			false, // autoAck
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			slog.Error("erro iniciando consumo em lote do RabbitMQ; nova tentativa após reconexão", "err", err)
+			continue
+		}
+
+		err = r.consumeBatchLoop(ctx, msgs, batchSize, batchTimeout, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			slog.Warn("canal de consumo em lote do RabbitMQ caiu; aguardando reconexão", "err", err)
+			continue
+		}
+		return nil
+	}
+}
+
+func (r *RabbitMQ) consumeBatchLoop(ctx context.Context, msgs <-chan amqp.Delivery, batchSize int, batchTimeout time.Duration, handler func([]Job) []error) error {
+	for {
+		jobs, deliveries, err := collectBatch(ctx, msgs, batchSize, batchTimeout)
+		if len(jobs) == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		logx.Trace("queue", "lote de mensagens recebido do RabbitMQ", "queue", r.queueName, "tamanho", len(jobs))
+
+		errs := handler(jobs)
+		if len(errs) != len(jobs) {
+			slog.Error("handler de lote devolveu número de erros diferente do lote",
+				"lote", len(jobs), "erros", len(errs))
+			errs = make([]error, len(jobs))
+		}
+
+		for i, msg := range deliveries {
+			r.ackOrRetry(ctx, msg, jobs[i], errs[i])
+		}
+
+		if err != nil {
+			return err
 		}
 	}
 }
 
+// QueueDepth é a profundidade (mensagens prontas pra entrega) de uma fila do
+// pipeline, devolvida por Stats.
+type QueueDepth struct {
+	Queue    string `json:"queue"`
+	Messages int    `json:"messages"`
+}
+
+// Stats inspeciona (passivamente, sem declarar) a fila principal, cada
+// degrau de retry e a dead-letter queue, devolvendo a profundidade de cada
+// uma. Usado pelo endpoint administrativo GET /admin/queue/stats.
+func (r *RabbitMQ) Stats() ([]QueueDepth, error) {
+	ch, err := r.waitReady(context.Background(), r.publishBlocking)
+	if err != nil {
+		return nil, fmt.Errorf("erro consultando filas: %w", err)
+	}
+
+	names := make([]string, 0, len(r.retryTiers)+2)
+	names = append(names, r.queueName)
+	for _, tier := range r.retryTiers {
+		names = append(names, tier.queueName)
+	}
+	names = append(names, r.dlqName)
+
+	stats := make([]QueueDepth, 0, len(names))
+	for _, name := range names {
+		q, err := ch.QueueInspect(name)
+		if err != nil {
+			return nil, fmt.Errorf("erro inspecionando fila %q: %w", name, err)
+		}
+		stats = append(stats, QueueDepth{Queue: name, Messages: q.Messages})
+	}
+
+	return stats, nil
+}
+
+// SetDeadLetterHook satisfaz Broker — equivalente a atribuir diretamente o
+// campo DeadLetterHook, disponível pra quem já tem um *RabbitMQ concreto.
+func (r *RabbitMQ) SetDeadLetterHook(fn func(job Job, retries int, lastErr error)) {
+	r.DeadLetterHook = fn
+}
+
 func (r *RabbitMQ) Close() error {
-	if r.ch != nil {
-		_ = r.ch.Close()
+	r.closeOnce.Do(func() {
+		close(r.stopCh)
+	})
+
+	r.mu.RLock()
+	ch, conn := r.ch, r.conn
+	r.mu.RUnlock()
+
+	if ch != nil {
+		_ = ch.Close()
 	}
-	if r.conn != nil {
-		return r.conn.Close()
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
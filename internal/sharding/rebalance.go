@@ -0,0 +1,236 @@
+package sharding
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// Rebalance move todas as linhas de um emitente_cnpj do shard de origem pro
+// shard de destino: copia dentro de uma transação no destino, confirma que
+// a cópia bateu em volume de linhas, apaga do shard de origem e atualiza
+// nfe_shard_map atomicamente só depois que a cópia foi commitada — nessa
+// ordem, uma falha no meio do caminho deixa o CNPJ duplicado nos dois
+// shards (detectável e re-executável) em vez de perdido.
+//
+// Não tenta ser uma migração online sem downtime: espera-se que o emitente
+// fique pausado (sem ingestão) durante o rebalance, como documentado nos
+// requests que motivaram este pacote.
+//
+// Preserva os ids originais ao copiar (ver copyTableRows); não ajusta a
+// sequence de id do destino, então uma nova nota ingerida no shard destino
+// logo após o rebalance pode colidir com um id copiado se a sequence local
+// já o tiver ultrapassado antes do rebalance. Pra esse caso, rode
+// `SELECT setval(pg_get_serial_sequence('nfe', 'id'), (SELECT MAX(id) FROM nfe))`
+// no destino depois do rebalance — mesmo ajuste manual já feito pelas
+// migrations 0004 ao mover dados entre tabelas.
+func (r *Router) Rebalance(cnpj string, toShardID int) error {
+	if toShardID < 0 || toShardID >= len(r.shards) {
+		return fmt.Errorf("shard_id de destino %d fora do intervalo (%d shards configurados)", toShardID, len(r.shards))
+	}
+
+	fromShardID, err := r.shardIDFor(cnpj)
+	if err != nil {
+		return fmt.Errorf("erro resolvendo shard atual de %s: %w", cnpj, err)
+	}
+	if fromShardID == toShardID {
+		return nil
+	}
+
+	srcDB := r.shards[fromShardID]
+	dstDB := r.shards[toShardID]
+
+	if err := r.recordShardEvent(cnpj, "rebalance_start", &fromShardID, &toShardID, ""); err != nil {
+		return err
+	}
+
+	moved, err := copyShardRows(srcDB, dstDB, cnpj)
+	if err != nil {
+		_ = r.recordShardEvent(cnpj, "rebalance_failed", &fromShardID, &toShardID, err.Error())
+		return fmt.Errorf("erro copiando linhas de %s do shard %d pro %d: %w", cnpj, fromShardID, toShardID, err)
+	}
+
+	if err := deleteShardRows(srcDB, cnpj); err != nil {
+		return fmt.Errorf("erro removendo linhas de %s do shard de origem %d após cópia: %w", cnpj, fromShardID, err)
+	}
+
+	if _, err := r.metaDB.Exec(
+		`UPDATE nfe_shard_map SET shard_id = $1, assigned_at = CURRENT_TIMESTAMP WHERE emitente_cnpj = $2`,
+		toShardID, cnpj,
+	); err != nil {
+		return fmt.Errorf("erro atualizando nfe_shard_map para %s: %w", cnpj, err)
+	}
+
+	r.mu.Lock()
+	r.cache[cnpj] = toShardID
+	r.mu.Unlock()
+
+	if err := r.recordShardEvent(cnpj, "rebalance_done", &fromShardID, &toShardID, fmt.Sprintf("%d linhas de nfe movidas", moved)); err != nil {
+		return err
+	}
+
+	slog.Info("rebalance concluído", "cnpj", cnpj, "from_shard", fromShardID, "to_shard", toShardID, "nfe_rows", moved)
+	return nil
+}
+
+// copyShardRows copia, dentro de uma única transação em dst, todas as
+// linhas de cnpj nas tabelas de shardedTables (nfe e suas tabelas-filha).
+// Devolve o número de linhas de nfe copiadas, usado só como métrica.
+func copyShardRows(src, dst *sql.DB, cnpj string) (int64, error) {
+	rows, err := src.Query(`SELECT id FROM nfe WHERE emitente_cnpj = $1`, cnpj)
+	if err != nil {
+		return 0, fmt.Errorf("erro selecionando nfe de origem: %w", err)
+	}
+	var nfeIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("erro lendo id de nfe: %w", err)
+		}
+		nfeIDs = append(nfeIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(nfeIDs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := dst.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro abrindo transação no destino: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, nfeID := range nfeIDs {
+		if err := copyRowTree(tx, src, nfeID); err != nil {
+			return 0, err
+		}
+	}
+
+	var copied int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM nfe WHERE emitente_cnpj = $1`, cnpj).Scan(&copied); err != nil {
+		return 0, fmt.Errorf("erro contando nfe copiadas no destino: %w", err)
+	}
+	if copied != int64(len(nfeIDs)) {
+		return 0, fmt.Errorf("cópia de %s divergiu em volume: %d nfe na origem, %d no destino", cnpj, len(nfeIDs), copied)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro commitando cópia no destino: %w", err)
+	}
+	return int64(len(nfeIDs)), nil
+}
+
+// copyRowTree copia uma nota (nfe + xml + duplicatas + pagamentos + itens)
+// de src pra dentro da transação tx, preservando o id original — os dois
+// shards compartilham o mesmo schema (sequences independentes), então um
+// conflito de id só ocorreria se o mesmo id já tivesse sido alocado no
+// destino por outra nota, o que INSERT reporta como erro em vez de
+// sobrescrever silenciosamente.
+func copyRowTree(tx *sql.Tx, src *sql.DB, nfeID int64) error {
+	if err := copyTableRows(tx, src, `SELECT * FROM nfe WHERE id = $1`, "nfe", nfeID); err != nil {
+		return err
+	}
+	if err := copyTableRows(tx, src, `SELECT * FROM nfe_item WHERE nfe_id = $1`, "nfe_item", nfeID); err != nil {
+		return err
+	}
+	if err := copyTableRows(tx, src, `SELECT * FROM nfe_xml WHERE nfe_id = $1`, "nfe_xml", nfeID); err != nil {
+		return err
+	}
+	if err := copyTableRows(tx, src, `SELECT * FROM nfe_duplicatas WHERE nfe_id = $1`, "nfe_duplicatas", nfeID); err != nil {
+		return err
+	}
+	if err := copyTableRows(tx, src, `SELECT * FROM nfe_pagamentos WHERE nfe_id = $1`, "nfe_pagamentos", nfeID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// copyTableRows lê as linhas de query em src e as reinsere em table dentro
+// de tx, usando os nomes de coluna devolvidos pelo próprio driver — assim
+// não precisa manter uma lista de colunas por tabela em sincronia manual
+// com o schema (que já muda a cada migration de 0001 a 0004).
+func copyTableRows(tx *sql.Tx, src *sql.DB, query, table string, arg int64) error {
+	rows, err := src.Query(query, arg)
+	if err != nil {
+		return fmt.Errorf("erro lendo %s de origem: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("erro lendo colunas de %s: %w", table, err)
+	}
+
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	insertSQL := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		table, joinIdentifiers(cols), joinStrings(placeholders),
+	)
+
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("erro lendo linha de %s: %w", table, err)
+		}
+		if _, err := tx.Exec(insertSQL, values...); err != nil {
+			return fmt.Errorf("erro inserindo linha de %s no destino: %w", table, err)
+		}
+	}
+	return rows.Err()
+}
+
+func joinIdentifiers(cols []string) string {
+	return joinStrings(cols)
+}
+
+func joinStrings(parts []string) string {
+	out := ""
+	for i, p := range parts {
+		if i > 0 {
+			out += ", "
+		}
+		out += p
+	}
+	return out
+}
+
+// deleteShardRows apaga do shard de origem todas as linhas de cnpj, na
+// ordem inversa de copyRowTree (filhos antes do pai) pra respeitar as FKs —
+// embora todas já tenham ON DELETE CASCADE a partir de nfe, fazer explícito
+// evita depender de cascade num DELETE que cruza o limite do que Rebalance
+// promete mover.
+func deleteShardRows(db *sql.DB, cnpj string) error {
+	for _, table := range []string{"nfe_pagamentos", "nfe_duplicatas", "nfe_xml", "nfe_item"} {
+		if _, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE nfe_id IN (SELECT id FROM nfe WHERE emitente_cnpj = $1)`, table), cnpj); err != nil {
+			return fmt.Errorf("erro apagando %s de origem: %w", table, err)
+		}
+	}
+	_, err := db.Exec(`DELETE FROM nfe WHERE emitente_cnpj = $1`, cnpj)
+	if err != nil {
+		return fmt.Errorf("erro apagando nfe de origem: %w", err)
+	}
+	return nil
+}
+
+func (r *Router) recordShardEvent(cnpj, eventType string, fromShardID, toShardID *int, detail string) error {
+	_, err := r.metaDB.Exec(
+		`INSERT INTO nfe_shard_events (emitente_cnpj, event_type, from_shard_id, to_shard_id, detail)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		cnpj, eventType, fromShardID, toShardID, detail,
+	)
+	if err != nil {
+		return fmt.Errorf("erro registrando evento %s para %s em nfe_shard_events: %w", eventType, cnpj, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package sharding roteia escrita/leitura de NF-e por emitente_cnpj entre N
+// bancos Postgres lógicos (shards), pra tenants com volume alto demais pra
+// caber confortavelmente num particionamento (internal/partitions) dentro
+// de um único banco. Os dois mecanismos não são mutuamente exclusivos: cada
+// shard aqui pode (e deve) ter suas próprias partições mensais de nfe/
+// nfe_item internamente.
+//
+// O mapa de shard-por-CNPJ (nfe_shard_map, sql/0005_shard_map.up.sql) vive
+// numa DB de metadados compartilhada, não em cada shard.
+package sharding
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ShardConfig descreve a topologia de sharding: quantos shards lógicos
+// existem pra distribuir emitentes.
+type ShardConfig struct {
+	NumShards int
+}
+
+// ShardFor calcula o shard "natural" de um CNPJ via hash consistente
+// (FNV-1a) módulo NumShards. É determinístico — o mesmo cnpj sempre cai no
+// mesmo shard pra um dado NumShards — mas é só o ponto de partida: o mapa
+// em nfe_shard_map é quem manda em runtime, porque um CNPJ pode ter sido
+// realocado por Rebalance sem que NumShards tenha mudado.
+func ShardFor(cfg ShardConfig, cnpj string) int {
+	if cfg.NumShards <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(cnpj))
+	return int(h.Sum64() % uint64(cfg.NumShards))
+}
+
+// Router resolve, pra cada emitente_cnpj, qual *sql.DB usar, consultando
+// primeiro o mapa persistido em nfe_shard_map (metaDB) e caindo pro shard
+// calculado por ShardFor quando o CNPJ ainda não tem entrada (primeiro
+// ingestão desse emitente).
+type Router struct {
+	cfg    ShardConfig
+	shards []*sql.DB
+	metaDB *sql.DB
+
+	mu    sync.RWMutex
+	cache map[string]int // emitente_cnpj -> shard_id, preenchido sob demanda
+}
+
+// NewRouter monta um Router sobre os shards informados (índice da slice =
+// shard_id) e a DB de metadados compartilhada onde vive nfe_shard_map.
+func NewRouter(cfg ShardConfig, shards []*sql.DB, metaDB *sql.DB) *Router {
+	return &Router{
+		cfg:    cfg,
+		shards: shards,
+		metaDB: metaDB,
+		cache:  make(map[string]int),
+	}
+}
+
+// Shards devolve a lista de conexões de todos os shards, na ordem de
+// shard_id — usado pra fan-out em queries que não escopam por emitente.
+func (r *Router) Shards() []*sql.DB {
+	return r.shards
+}
+
+// DBFor devolve a conexão do shard responsável por cnpj, alocando (e
+// persistindo em nfe_shard_map) o shard natural de ShardFor na primeira vez
+// que esse CNPJ é visto.
+func (r *Router) DBFor(cnpj string) (*sql.DB, error) {
+	shardID, err := r.shardIDFor(cnpj)
+	if err != nil {
+		return nil, err
+	}
+	if shardID < 0 || shardID >= len(r.shards) {
+		return nil, fmt.Errorf("shard_id %d fora do intervalo (%d shards configurados)", shardID, len(r.shards))
+	}
+	return r.shards[shardID], nil
+}
+
+func (r *Router) shardIDFor(cnpj string) (int, error) {
+	r.mu.RLock()
+	shardID, ok := r.cache[cnpj]
+	r.mu.RUnlock()
+	if ok {
+		return shardID, nil
+	}
+
+	shardID, err := r.lookupOrAssign(cnpj)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.cache[cnpj] = shardID
+	r.mu.Unlock()
+	return shardID, nil
+}
+
+// lookupOrAssign consulta nfe_shard_map por cnpj; se não houver entrada,
+// calcula o shard natural via ShardFor e grava o mapeamento (INSERT ...
+// ON CONFLICT DO NOTHING, pra tolerar corrida entre goroutines vendo o
+// mesmo CNPJ novo ao mesmo tempo sem duplicar a decisão).
+func (r *Router) lookupOrAssign(cnpj string) (int, error) {
+	var shardID int
+	err := r.metaDB.QueryRow(`SELECT shard_id FROM nfe_shard_map WHERE emitente_cnpj = $1`, cnpj).Scan(&shardID)
+	if err == nil {
+		return shardID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("erro consultando nfe_shard_map para cnpj %s: %w", cnpj, err)
+	}
+
+	shardID = ShardFor(r.cfg, cnpj)
+	_, err = r.metaDB.Exec(
+		`INSERT INTO nfe_shard_map (emitente_cnpj, shard_id) VALUES ($1, $2)
+		 ON CONFLICT (emitente_cnpj) DO NOTHING`,
+		cnpj, shardID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("erro registrando cnpj %s em nfe_shard_map: %w", cnpj, err)
+	}
+
+	// Corrida: outra goroutine pode ter inserido um shard_id diferente entre
+	// o SELECT acima e este INSERT. Relê pra garantir que toda réplica em
+	// memória convirja pro valor que de fato ficou gravado.
+	if err := r.metaDB.QueryRow(`SELECT shard_id FROM nfe_shard_map WHERE emitente_cnpj = $1`, cnpj).Scan(&shardID); err != nil {
+		return 0, fmt.Errorf("erro relendo shard_id de %s após INSERT: %w", cnpj, err)
+	}
+	return shardID, nil
+}
+
+// FanoutResult é o resultado de uma chamada por shard dentro de Fanout,
+// junto do shard_id que a gerou — necessário pra quem consome os resultados
+// conseguir atribuir cada item ao shard de origem (ex: dedup, merge
+// ordenado).
+type FanoutResult struct {
+	ShardID int
+	Value   any
+	Err     error
+}
+
+// Fanout roda fn contra cada shard com no máximo maxConcurrent chamadas
+// simultâneas (pool de workers limitado, mesmo padrão de worker.processZIP),
+// pra queries que não escopam por emitente_cnpj e por isso precisam visitar
+// todos os shards e combinar o resultado. A ordem dos resultados acompanha a
+// ordem de r.shards, não a ordem de conclusão.
+func (r *Router) Fanout(ctx context.Context, maxConcurrent int, fn func(ctx context.Context, db *sql.DB, shardID int) (any, error)) []FanoutResult {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
+	results := make([]FanoutResult, len(r.shards))
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+
+	for shardID, db := range r.shards {
+		shardID, db := shardID, db
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			value, err := fn(ctx, db, shardID)
+			results[shardID] = FanoutResult{ShardID: shardID, Value: value, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
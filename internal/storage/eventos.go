@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// EventoTipo enumera os estados do ciclo de ingestão de uma NF-e
+// registrados em nfe_eventos. Os valores numéricos são estáveis (gravados
+// no banco) — não reordenar, só acrescentar no fim.
+type EventoTipo int16
+
+const (
+	EventoRecebido EventoTipo = iota + 1
+	EventoParseado
+	EventoValidado
+	EventoAutorizado
+	EventoCancelado
+	EventoDenegado
+	EventoDuplicataIgnorada
+	EventoReimportado
+)
+
+// RecordEvento grava uma linha em nfe_eventos. nfeID pode ser nil (falha
+// antes da NFe existir no banco); chave pode ser vazia se ainda não foi
+// possível extraí-la do XML. detalhes é serializado como JSONB quando não
+// nil; procErr, quando não nil, preenche a coluna erro (e é o que torna o
+// evento visível no índice parcial idx_nfe_eventos_erro).
+func RecordEvento(db *sql.DB, nfeID *int64, chave string, evento EventoTipo, origem string, detalhes map[string]any, procErr error) error {
+	var detalhesJSON []byte
+	if detalhes != nil {
+		var err error
+		detalhesJSON, err = json.Marshal(detalhes)
+		if err != nil {
+			return fmt.Errorf("erro serializando detalhes do evento %d: %w", evento, err)
+		}
+	}
+
+	var erroText interface{}
+	if procErr != nil {
+		erroText = procErr.Error()
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO nfe_eventos (nfe_id, chave_acesso, evento, origem, detalhes, erro)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, nfeID, nullableString(chave), int16(evento), origem, detalhesJSON, erroText)
+	if err != nil {
+		return fmt.Errorf("erro gravando evento %d em nfe_eventos: %w", evento, err)
+	}
+
+	return nil
+}
@@ -1,23 +1,88 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 
+	"nfe-drop/internal/logx"
 	"nfe-drop/internal/nfe"
 )
 
+// RecordProcessingFailure persiste uma falha definitiva de processamento
+// (job que esgotou as tentativas de retry ou que falhou no parse, seja via
+// fila ou via o modo polling) em nfe_processing_failures, pra que operadores
+// consigam inspecionar falhas via SQL sem vasculhar FailedDir.
+func RecordProcessingFailure(db *sql.DB, filename, chave, errorClass string, attempts int, lastErr error) error {
+	var lastErrText interface{}
+	if lastErr != nil {
+		lastErrText = lastErr.Error()
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO nfe_processing_failures (filename, chave_acesso, error_class, last_error, attempts)
+		VALUES ($1, $2, $3, $4, $5)
+	`, filename, nullableString(chave), errorClass, lastErrText, attempts)
+	if err != nil {
+		return fmt.Errorf("erro gravando falha de processamento em nfe_processing_failures: %w", err)
+	}
+
+	return nil
+}
+
 // ErrNFeAlreadyExists indica que a NFe já está no banco (chave_acesso única).
 var ErrNFeAlreadyExists = errors.New("nfe já existe")
 
+// UpsertMode controla o que SaveNFeWithRelations faz ao encontrar uma
+// chave_acesso já existente no banco. Relevante pra NF-e que são
+// reemitidas (cancelamento/correção) com o mesmo número de chave, ou pra
+// reprocessar depois de um fix no schema/parser.
+type UpsertMode int
+
+const (
+	// Skip é o comportamento histórico: qualquer duplicata vira
+	// ErrNFeAlreadyExists e o caller decide o que fazer (hoje, ignorar).
+	Skip UpsertMode = iota
+	// UpdateIfHashDiffers só atualiza o cabeçalho da NFe se
+	// HashIntegridade divergir do valor já salvo; se for igual, é no-op
+	// e devolve o id existente.
+	UpdateIfHashDiffers
+	// ReplaceChildren faz o mesmo que UpdateIfHashDiffers e, além disso,
+	// descarta e reinsere nfe_item, nfe_duplicatas e nfe_pagamentos.
+	ReplaceChildren
+)
+
 // SaveNFeWithRelations insere a NFe, itens, duplicatas, pagamentos
-// e o XML bruto (nfe_xml) em uma única transação.
-func SaveNFeWithRelations(db *sql.DB, parsed *nfe.ParsedNFe) (nfeID int64, err error) {
+// e o XML bruto (nfe_xml) em uma única transação. Se já existir uma NFe com
+// a mesma chave_acesso, o comportamento depende de mode (ver UpsertMode).
+func SaveNFeWithRelations(db *sql.DB, parsed *nfe.ParsedNFe, mode UpsertMode) (nfeID int64, err error) {
+	logx.Trace("db", "iniciando transação para salvar NFe", "chave", parsed.ChaveAcesso)
+
+	if mode != Skip {
+		existingID, existingHash, found, lookupErr := lookupNFeByChave(db, parsed.ChaveAcesso)
+		if lookupErr != nil {
+			return 0, lookupErr
+		}
+		if found {
+			if parsed.HashIntegridade == existingHash {
+				slog.Info("NFe já existe com hash idêntico, nenhuma mudança necessária",
+					"nfe_id", existingID,
+					"chave", parsed.ChaveAcesso,
+				)
+				return existingID, nil
+			}
+			return upsertExistingNFe(db, existingID, existingHash, parsed, mode)
+		}
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		return 0, fmt.Errorf("erro iniciando transação: %w", err)
@@ -39,19 +104,21 @@ func SaveNFeWithRelations(db *sql.DB, parsed *nfe.ParsedNFe) (nfeID int64, err e
 		return 0, err
 	}
 
-	if err = insertNFeXML(tx, nfeID, parsed); err != nil {
+	if err = insertNFeXML(tx, nfeID, parsed, 1); err != nil {
 		return 0, err
 	}
 
-	if err = insertItens(tx, nfeID, parsed.Itens); err != nil {
+	emissao := strings.TrimSpace(parsed.EmissaoDate)
+
+	if err = insertItens(tx, nfeID, emissao, parsed.Itens); err != nil {
 		return 0, err
 	}
 
-	if err = insertDuplicatas(tx, nfeID, parsed.Duplicatas); err != nil {
+	if err = insertDuplicatas(tx, nfeID, emissao, parsed.Duplicatas); err != nil {
 		return 0, err
 	}
 
-	if err = insertPagamentos(tx, nfeID, parsed.Pagamentos); err != nil {
+	if err = insertPagamentos(tx, nfeID, emissao, parsed.Pagamentos); err != nil {
 		return 0, err
 	}
 
@@ -70,6 +137,719 @@ func SaveNFeWithRelations(db *sql.DB, parsed *nfe.ParsedNFe) (nfeID int64, err e
 	return nfeID, nil
 }
 
+// lookupNFeByChave busca o id e o hash_integridade já salvos para chave, sem
+// abrir transação. found é false se a chave ainda não existir no banco.
+func lookupNFeByChave(db *sql.DB, chave string) (id int64, hash string, found bool, err error) {
+	err = db.QueryRow(`SELECT id, hash_integridade FROM nfe WHERE chave_acesso = $1`, chave).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, fmt.Errorf("erro buscando nfe existente (chave=%s): %w", chave, err)
+	}
+	return id, hash, true, nil
+}
+
+// upsertExistingNFe atualiza o cabeçalho de uma NFe existente cujo hash
+// divergiu do incoming, insere uma nova versão de nfe_xml (preservando o
+// histórico) e, em ReplaceChildren, descarta e reinsere itens/duplicatas/
+// pagamentos.
+func upsertExistingNFe(db *sql.DB, existingID int64, oldHash string, parsed *nfe.ParsedNFe, mode UpsertMode) (nfeID int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro iniciando transação de upsert: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	nfeID, err = updateNFeHeader(tx, parsed)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := nextXMLVersion(tx, nfeID)
+	if err != nil {
+		return 0, err
+	}
+	if err = insertNFeXML(tx, nfeID, parsed, version); err != nil {
+		return 0, err
+	}
+
+	if mode == ReplaceChildren {
+		if err = deleteChildren(tx, nfeID); err != nil {
+			return 0, err
+		}
+		emissao := strings.TrimSpace(parsed.EmissaoDate)
+		if err = insertItens(tx, nfeID, emissao, parsed.Itens); err != nil {
+			return 0, err
+		}
+		if err = insertDuplicatas(tx, nfeID, emissao, parsed.Duplicatas); err != nil {
+			return 0, err
+		}
+		if err = insertPagamentos(tx, nfeID, emissao, parsed.Pagamentos); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro no commit do upsert: %w", err)
+	}
+
+	slog.Info("NFe atualizada via upsert (hash_integridade divergente)",
+		"nfe_id", nfeID,
+		"chave", parsed.ChaveAcesso,
+		"hash_antigo", oldHash,
+		"hash_novo", parsed.HashIntegridade,
+		"xml_version", version,
+		"mode", mode,
+	)
+
+	return nfeID, nil
+}
+
+// updateNFeHeader atualiza o cabeçalho da NFe identificada por
+// parsed.ChaveAcesso, só de fato mutando a linha se hash_integridade
+// divergir (WHERE ... AND hash_integridade <> $1). O chamador já garantiu
+// que os hashes divergem, então RETURNING id sempre deve casar uma linha.
+func updateNFeHeader(tx *sql.Tx, p *nfe.ParsedNFe) (int64, error) {
+	emissao := strings.TrimSpace(p.EmissaoDate)
+	if emissao == "" {
+		return 0, fmt.Errorf("emissao vazia para chave %s", p.ChaveAcesso)
+	}
+	dataAut := toNullDate(p.DataAutorizacao)
+
+	const q = `
+UPDATE nfe SET
+	hash_integridade = $1,
+	modelo = $2,
+	serie = $3,
+	numero = $4,
+	emissao = $5,
+	tipo_operacao = $6,
+	tipo_ambiente = $7,
+	natureza_operacao = $8,
+	protocolo_autorizacao = $9,
+	data_autorizacao = $10,
+	codigo_status = $11,
+	emitente_cnpj = $12,
+	emitente_razao = $13,
+	dest_cnpj_cpf = $14,
+	dest_razao = $15,
+	valor_total_nota = $16,
+	valor_produtos = $17,
+	valor_desconto = $18,
+	valor_icms = $19,
+	valor_ipi = $20,
+	valor_pis = $21,
+	valor_cofins = $22,
+	valor_ii = $23,
+	valor_frete = $24,
+	valor_seguro = $25,
+	modalidade_frete = $26,
+	updated_at = CURRENT_TIMESTAMP(3)
+WHERE chave_acesso = $27 AND hash_integridade <> $1
+RETURNING id;
+`
+
+	var id int64
+	err := tx.QueryRow(
+		q,
+		p.HashIntegridade,
+		p.Modelo,
+		p.Serie,
+		p.Numero,
+		emissao,
+		p.TipoOperacao,
+		p.TipoAmbiente,
+		p.NaturezaOperacao,
+		nullableString(p.ProtocoloAut),
+		dataAut,
+		p.CodigoStatus,
+		p.EmitenteCNPJ,
+		p.EmitenteRazao,
+		nullableString(p.DestCNPJCPF),
+		nullableString(p.DestRazao),
+		p.ValorTotalNota,
+		p.ValorProdutos,
+		p.ValorDesconto,
+		p.ValorICMS,
+		p.ValorIPI,
+		p.ValorPIS,
+		p.ValorCOFINS,
+		p.ValorII,
+		p.ValorFrete,
+		p.ValorSeguro,
+		p.ModalidadeFrete,
+		p.ChaveAcesso,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("erro atualizando nfe (chave=%s): %w", p.ChaveAcesso, err)
+	}
+
+	return id, nil
+}
+
+// deleteChildren remove itens, duplicatas e pagamentos de uma NFe existente,
+// sem tocar em nfe nem em nfe_xml (cujas versões anteriores são preservadas).
+func deleteChildren(tx *sql.Tx, nfeID int64) error {
+	if _, err := tx.Exec(`DELETE FROM nfe_item WHERE nfe_id = $1`, nfeID); err != nil {
+		return fmt.Errorf("erro removendo nfe_item antes do upsert (nfe_id=%d): %w", nfeID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM nfe_duplicatas WHERE nfe_id = $1`, nfeID); err != nil {
+		return fmt.Errorf("erro removendo nfe_duplicatas antes do upsert (nfe_id=%d): %w", nfeID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM nfe_pagamentos WHERE nfe_id = $1`, nfeID); err != nil {
+		return fmt.Errorf("erro removendo nfe_pagamentos antes do upsert (nfe_id=%d): %w", nfeID, err)
+	}
+	return nil
+}
+
+// nfeStagingColumns são as colunas da tabela nfe (exceto id/created_at/
+// updated_at), na mesma ordem usada pelo staging table e pelo INSERT...SELECT
+// do COPY em lote.
+var nfeStagingColumns = []string{
+	"chave_acesso", "hash_integridade", "modelo", "serie", "numero", "emissao",
+	"tipo_operacao", "tipo_ambiente", "natureza_operacao", "protocolo_autorizacao",
+	"data_autorizacao", "codigo_status", "emitente_cnpj", "emitente_razao",
+	"dest_cnpj_cpf", "dest_razao", "valor_total_nota", "valor_produtos",
+	"valor_desconto", "valor_icms", "valor_ipi", "valor_pis", "valor_cofins",
+	"valor_ii", "valor_frete", "valor_seguro", "modalidade_frete",
+}
+
+// BatchResult resume o resultado de um SaveNFeBatch: quantas NFe novas foram
+// inseridas e quais chaves já existiam no banco (tratadas como
+// ErrNFeAlreadyExists individualmente, sem falhar o lote inteiro).
+type BatchResult struct {
+	Inserted   int
+	Duplicates []string
+}
+
+// SaveNFeBatch persiste um lote de NFe usando pgx.CopyFrom, numa única
+// transação: os cabeçalhos entram numa staging table temporária via COPY,
+// depois um INSERT...SELECT...ON CONFLICT DO NOTHING os move pra nfe
+// devolvendo os ids gerados, e os filhos (XML, itens, duplicatas,
+// pagamentos) são copiados em seguida usando esses ids. Muito mais rápido
+// que SaveNFeWithRelations por NFe quando processando backfills grandes.
+func SaveNFeBatch(ctx context.Context, db *sql.DB, batch []*nfe.ParsedNFe) (BatchResult, error) {
+	if len(batch) == 0 {
+		return BatchResult{}, nil
+	}
+
+	conn, err := stdlib.AcquireConn(db)
+	if err != nil {
+		return BatchResult{}, fmt.Errorf("erro obtendo conexão pgx para COPY em lote: %w", err)
+	}
+	defer func() {
+		if err := stdlib.ReleaseConn(db, conn); err != nil {
+			slog.Error("erro devolvendo conexão pgx ao pool após COPY em lote", "err", err)
+		}
+	}()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return BatchResult{}, fmt.Errorf("erro iniciando transação de COPY em lote: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `
+CREATE TEMP TABLE nfe_staging (
+	chave_acesso CHAR(44), hash_integridade CHAR(64), modelo SMALLINT, serie INTEGER,
+	numero INTEGER, emissao TIMESTAMP(3), tipo_operacao SMALLINT, tipo_ambiente SMALLINT,
+	natureza_operacao VARCHAR(255), protocolo_autorizacao VARCHAR(50),
+	data_autorizacao TIMESTAMP(3), codigo_status SMALLINT, emitente_cnpj CHAR(14),
+	emitente_razao VARCHAR(255), dest_cnpj_cpf CHAR(14), dest_razao VARCHAR(255),
+	valor_total_nota NUMERIC(15,2), valor_produtos NUMERIC(15,2), valor_desconto NUMERIC(15,2),
+	valor_icms NUMERIC(15,2), valor_ipi NUMERIC(15,2), valor_pis NUMERIC(15,2),
+	valor_cofins NUMERIC(15,2), valor_ii NUMERIC(15,2), valor_frete NUMERIC(15,2),
+	valor_seguro NUMERIC(15,2), modalidade_frete SMALLINT
+) ON COMMIT DROP;
+`); err != nil {
+		return BatchResult{}, fmt.Errorf("erro criando staging table de COPY: %w", err)
+	}
+
+	headerRows := make([][]interface{}, 0, len(batch))
+	for _, p := range batch {
+		emissao := strings.TrimSpace(p.EmissaoDate)
+		if emissao == "" {
+			err = fmt.Errorf("emissao vazia para chave %s", p.ChaveAcesso)
+			return BatchResult{}, err
+		}
+		emissaoTime, perr := time.Parse("2006-01-02", emissao)
+		if perr != nil {
+			err = fmt.Errorf("emissao inválida para chave %s: %w", p.ChaveAcesso, perr)
+			return BatchResult{}, err
+		}
+
+		headerRows = append(headerRows, []interface{}{
+			p.ChaveAcesso, p.HashIntegridade, p.Modelo, p.Serie, p.Numero, emissaoTime,
+			p.TipoOperacao, p.TipoAmbiente, p.NaturezaOperacao, nullableString(p.ProtocoloAut),
+			copyNullableDate(p.DataAutorizacao), p.CodigoStatus, p.EmitenteCNPJ, p.EmitenteRazao,
+			nullableString(p.DestCNPJCPF), nullableString(p.DestRazao), p.ValorTotalNota,
+			p.ValorProdutos, p.ValorDesconto, p.ValorICMS, p.ValorIPI, p.ValorPIS,
+			p.ValorCOFINS, p.ValorII, p.ValorFrete, p.ValorSeguro, p.ModalidadeFrete,
+		})
+	}
+
+	if _, err = tx.CopyFrom(ctx, pgx.Identifier{"nfe_staging"}, nfeStagingColumns, pgx.CopyFromRows(headerRows)); err != nil {
+		return BatchResult{}, fmt.Errorf("erro no COPY para staging table: %w", err)
+	}
+
+	insertSQL := fmt.Sprintf(`
+INSERT INTO nfe (%s)
+SELECT %s FROM nfe_staging
+ON CONFLICT (chave_acesso, emissao) DO NOTHING
+RETURNING id, chave_acesso;
+`, strings.Join(nfeStagingColumns, ", "), strings.Join(nfeStagingColumns, ", "))
+
+	rows, err := tx.Query(ctx, insertSQL)
+	if err != nil {
+		return BatchResult{}, fmt.Errorf("erro inserindo nfe a partir da staging table: %w", err)
+	}
+
+	idByChave := make(map[string]int64, len(batch))
+	for rows.Next() {
+		var id int64
+		var chave string
+		if err = rows.Scan(&id, &chave); err != nil {
+			rows.Close()
+			return BatchResult{}, fmt.Errorf("erro lendo id retornado do INSERT em lote: %w", err)
+		}
+		idByChave[chave] = id
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return BatchResult{}, fmt.Errorf("erro iterando resultado do INSERT em lote: %w", err)
+	}
+
+	var duplicates []string
+	var xmlRows, itemRows, dupRows, pagRows [][]interface{}
+
+	for _, p := range batch {
+		nfeID, ok := idByChave[p.ChaveAcesso]
+		if !ok {
+			duplicates = append(duplicates, p.ChaveAcesso)
+			slog.Info("NFe já existia no banco, ignorando no lote", "chave", p.ChaveAcesso)
+			continue
+		}
+
+		// emissao já foi validada e parseada no loop de headerRows acima;
+		// reparseamos aqui (é só um time.Parse de uma string curta) em vez
+		// de carregar um mapa chave->emissaoTime só pra atravessar os dois
+		// loops.
+		emissaoTime, perr := time.Parse("2006-01-02", strings.TrimSpace(p.EmissaoDate))
+		if perr != nil {
+			err = fmt.Errorf("emissao inválida para chave %s: %w", p.ChaveAcesso, perr)
+			return BatchResult{}, err
+		}
+
+		xmlRows = append(xmlRows, []interface{}{nfeID, emissaoTime, 1, string(p.XMLRaw), nil})
+
+		for _, it := range p.Itens {
+			itemRows = append(itemRows, []interface{}{
+				nfeID, emissaoTime, it.NItem, nullableString(it.Codigo), nullableString(it.CodigoEAN),
+				nullableString(it.Descricao), nullableString(it.NCM), nullableString(it.CFOP),
+				nullableString(it.Unidade), it.Quantidade, it.ValorUnitario, it.ValorTotalBruto,
+				it.ValorFrete, it.ValorSeguro, it.ValorDesconto, it.ValorOutros, it.IndTotal,
+				it.BaseCalculoICMS, it.ValorICMS, it.BaseCalculoICMSST, it.ValorICMSST,
+				it.ValorIPI, it.ValorPIS, it.ValorCOFINS,
+			})
+		}
+
+		for _, d := range p.Duplicatas {
+			dupRows = append(dupRows, []interface{}{
+				nfeID, emissaoTime, nullableString(d.Numero), copyNullableDate(d.DataVencimento), d.Valor,
+			})
+		}
+
+		for _, pag := range p.Pagamentos {
+			var ind interface{}
+			if pag.IndicadorPagamento != nil {
+				ind = *pag.IndicadorPagamento
+			}
+			pagRows = append(pagRows, []interface{}{
+				nfeID, emissaoTime, ind, nullableString(pag.MeioPagamento), pag.Valor,
+				nullableString(pag.CNPJCredenciadora), nullableString(pag.BandeiraCartao),
+				nullableString(pag.CodigoAutorizacao),
+			})
+		}
+	}
+
+	if len(xmlRows) > 0 {
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{"nfe_xml"}, []string{"nfe_id", "emissao", "version", "xml_raw", "xml_json"}, pgx.CopyFromRows(xmlRows)); err != nil {
+			return BatchResult{}, fmt.Errorf("erro no COPY de nfe_xml em lote: %w", err)
+		}
+	}
+	if len(itemRows) > 0 {
+		itemCols := []string{
+			"nfe_id", "emissao", "n_item", "codigo", "codigo_ean", "descricao", "ncm", "cfop", "unidade",
+			"quantidade", "valor_unit", "valor_total_bruto", "valor_frete", "valor_seguro",
+			"valor_desconto", "valor_outros", "ind_total", "base_calculo_icms", "valor_icms",
+			"base_calculo_icms_st", "valor_icms_st", "valor_ipi", "valor_pis", "valor_cofins",
+		}
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{"nfe_item"}, itemCols, pgx.CopyFromRows(itemRows)); err != nil {
+			return BatchResult{}, fmt.Errorf("erro no COPY de nfe_item em lote: %w", err)
+		}
+	}
+	if len(dupRows) > 0 {
+		dupCols := []string{"nfe_id", "emissao", "numero_duplicata", "data_vencimento", "valor_duplicata"}
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{"nfe_duplicatas"}, dupCols, pgx.CopyFromRows(dupRows)); err != nil {
+			return BatchResult{}, fmt.Errorf("erro no COPY de nfe_duplicatas em lote: %w", err)
+		}
+	}
+	if len(pagRows) > 0 {
+		pagCols := []string{
+			"nfe_id", "emissao", "indicador_pagamento", "meio_pagamento", "valor_pagamento",
+			"cnpj_credenciadora", "bandeira_cartao", "codigo_autorizacao",
+		}
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{"nfe_pagamentos"}, pagCols, pgx.CopyFromRows(pagRows)); err != nil {
+			return BatchResult{}, fmt.Errorf("erro no COPY de nfe_pagamentos em lote: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return BatchResult{}, fmt.Errorf("erro no commit da transação em lote: %w", err)
+	}
+
+	result := BatchResult{Inserted: len(idByChave), Duplicates: duplicates}
+
+	slog.Info("lote de NFe persistido via COPY",
+		"total", len(batch),
+		"inseridas", result.Inserted,
+		"duplicatas", len(result.Duplicates),
+	)
+
+	return result, nil
+}
+
+// ErrNFeNotFound indica que a chave de acesso consultada não existe no banco.
+var ErrNFeNotFound = errors.New("nfe não encontrada")
+
+// GetXMLRawByChave devolve o XML bruto (nfe_xml.xml_raw) de uma NFe já
+// persistida, buscando por chave_acesso. Usado pelo endpoint administrativo
+// de reprocessamento, que reparseia esse conteúdo e substitui o registro via
+// UpsertNFeWithRelations.
+func GetXMLRawByChave(db *sql.DB, chave string) ([]byte, error) {
+	var raw string
+	err := db.QueryRow(`
+		SELECT x.xml_raw
+		FROM nfe_xml x
+		JOIN nfe n ON n.id = x.nfe_id
+		WHERE n.chave_acesso = $1
+		ORDER BY x.version DESC
+		LIMIT 1
+	`, chave).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNFeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo xml_raw da NFe (chave=%s): %w", chave, err)
+	}
+	return []byte(raw), nil
+}
+
+// UpsertNFeWithRelations substitui a NFe existente (se houver, pela chave de
+// acesso de parsed) pelo conteúdo reparseado: a linha antiga e seus filhos
+// são removidos via ON DELETE CASCADE antes da reinserção, tudo numa única
+// transação. Usado pelo endpoint administrativo de reprocessamento, quando o
+// XML já está no banco e precisa ser reparseado (depois de um fix no parser,
+// por exemplo) sem esperar o arquivo original passar de novo pelo pipeline.
+func UpsertNFeWithRelations(db *sql.DB, parsed *nfe.ParsedNFe) (nfeID int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro iniciando transação de upsert: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM nfe WHERE chave_acesso = $1`, parsed.ChaveAcesso); err != nil {
+		return 0, fmt.Errorf("erro removendo NFe existente antes do upsert (chave=%s): %w", parsed.ChaveAcesso, err)
+	}
+
+	nfeID, err = insertNFe(tx, parsed)
+	if err != nil {
+		return 0, err
+	}
+	if err = insertNFeXML(tx, nfeID, parsed, 1); err != nil {
+		return 0, err
+	}
+	emissao := strings.TrimSpace(parsed.EmissaoDate)
+	if err = insertItens(tx, nfeID, emissao, parsed.Itens); err != nil {
+		return 0, err
+	}
+	if err = insertDuplicatas(tx, nfeID, emissao, parsed.Duplicatas); err != nil {
+		return 0, err
+	}
+	if err = insertPagamentos(tx, nfeID, emissao, parsed.Pagamentos); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro no commit do upsert: %w", err)
+	}
+
+	slog.Info("NFe substituída via upsert administrativo", "nfe_id", nfeID, "chave", parsed.ChaveAcesso)
+	return nfeID, nil
+}
+
+// NFeDetail é o cabeçalho de uma NFe junto com seus relacionamentos,
+// devolvido pelo endpoint administrativo GET /admin/nfe/{chave}.
+type NFeDetail struct {
+	ID                   int64      `json:"id"`
+	ChaveAcesso          string     `json:"chave_acesso"`
+	HashIntegridade      string     `json:"hash_integridade"`
+	Modelo               int        `json:"modelo"`
+	Serie                int        `json:"serie"`
+	Numero               int        `json:"numero"`
+	Emissao              time.Time  `json:"emissao"`
+	TipoOperacao         int        `json:"tipo_operacao"`
+	TipoAmbiente         int        `json:"tipo_ambiente"`
+	NaturezaOperacao     string     `json:"natureza_operacao"`
+	ProtocoloAutorizacao string     `json:"protocolo_autorizacao,omitempty"`
+	DataAutorizacao      *time.Time `json:"data_autorizacao,omitempty"`
+	CodigoStatus         *int       `json:"codigo_status,omitempty"`
+	EmitenteCNPJ         string     `json:"emitente_cnpj"`
+	EmitenteRazao        string     `json:"emitente_razao"`
+	DestCNPJCPF          string     `json:"dest_cnpj_cpf,omitempty"`
+	DestRazao            string     `json:"dest_razao,omitempty"`
+	ValorTotalNota       float64    `json:"valor_total_nota"`
+	ValorProdutos        float64    `json:"valor_produtos"`
+	ValorDesconto        float64    `json:"valor_desconto"`
+	ValorICMS            float64    `json:"valor_icms"`
+	ValorIPI             float64    `json:"valor_ipi"`
+	ValorPIS             float64    `json:"valor_pis"`
+	ValorCOFINS          float64    `json:"valor_cofins"`
+	ValorII              float64    `json:"valor_ii"`
+	ValorFrete           float64    `json:"valor_frete"`
+	ValorSeguro          float64    `json:"valor_seguro"`
+	ModalidadeFrete      *int       `json:"modalidade_frete,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+
+	Itens      []nfe.ParsedItem      `json:"itens"`
+	Duplicatas []nfe.ParsedDuplicata `json:"duplicatas"`
+	Pagamentos []nfe.ParsedPagamento `json:"pagamentos"`
+}
+
+// GetNFeDetail monta o detalhe completo (cabeçalho + itens + duplicatas +
+// pagamentos) de uma NFe pela chave de acesso, pro endpoint administrativo
+// GET /admin/nfe/{chave}.
+func GetNFeDetail(db *sql.DB, chave string) (*NFeDetail, error) {
+	var d NFeDetail
+	var (
+		protocoloAut    sql.NullString
+		dataAutorizacao sql.NullTime
+		codigoStatus    sql.NullInt64
+		destCNPJCPF     sql.NullString
+		destRazao       sql.NullString
+		valorDesconto   sql.NullFloat64
+		valorICMS       sql.NullFloat64
+		valorIPI        sql.NullFloat64
+		valorPIS        sql.NullFloat64
+		valorCOFINS     sql.NullFloat64
+		valorII         sql.NullFloat64
+		valorFrete      sql.NullFloat64
+		valorSeguro     sql.NullFloat64
+		modalidadeFrete sql.NullInt64
+	)
+
+	err := db.QueryRow(`
+		SELECT id, chave_acesso, hash_integridade, modelo, serie, numero, emissao,
+		       tipo_operacao, tipo_ambiente, natureza_operacao, protocolo_autorizacao,
+		       data_autorizacao, codigo_status, emitente_cnpj, emitente_razao,
+		       dest_cnpj_cpf, dest_razao, valor_total_nota, valor_produtos,
+		       valor_desconto, valor_icms, valor_ipi, valor_pis, valor_cofins,
+		       valor_ii, valor_frete, valor_seguro, modalidade_frete,
+		       created_at, updated_at
+		FROM nfe
+		WHERE chave_acesso = $1
+	`, chave).Scan(
+		&d.ID, &d.ChaveAcesso, &d.HashIntegridade, &d.Modelo, &d.Serie, &d.Numero, &d.Emissao,
+		&d.TipoOperacao, &d.TipoAmbiente, &d.NaturezaOperacao, &protocoloAut,
+		&dataAutorizacao, &codigoStatus, &d.EmitenteCNPJ, &d.EmitenteRazao,
+		&destCNPJCPF, &destRazao, &d.ValorTotalNota, &d.ValorProdutos,
+		&valorDesconto, &valorICMS, &valorIPI, &valorPIS, &valorCOFINS,
+		&valorII, &valorFrete, &valorSeguro, &modalidadeFrete,
+		&d.CreatedAt, &d.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNFeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo cabeçalho da NFe (chave=%s): %w", chave, err)
+	}
+
+	d.ProtocoloAutorizacao = protocoloAut.String
+	if dataAutorizacao.Valid {
+		t := dataAutorizacao.Time
+		d.DataAutorizacao = &t
+	}
+	if codigoStatus.Valid {
+		v := int(codigoStatus.Int64)
+		d.CodigoStatus = &v
+	}
+	d.DestCNPJCPF = destCNPJCPF.String
+	d.DestRazao = destRazao.String
+	d.ValorDesconto = valorDesconto.Float64
+	d.ValorICMS = valorICMS.Float64
+	d.ValorIPI = valorIPI.Float64
+	d.ValorPIS = valorPIS.Float64
+	d.ValorCOFINS = valorCOFINS.Float64
+	d.ValorII = valorII.Float64
+	d.ValorFrete = valorFrete.Float64
+	d.ValorSeguro = valorSeguro.Float64
+	if modalidadeFrete.Valid {
+		v := int(modalidadeFrete.Int64)
+		d.ModalidadeFrete = &v
+	}
+
+	if d.Itens, err = fetchItensByNFeID(db, d.ID); err != nil {
+		return nil, err
+	}
+	if d.Duplicatas, err = fetchDuplicatasByNFeID(db, d.ID); err != nil {
+		return nil, err
+	}
+	if d.Pagamentos, err = fetchPagamentosByNFeID(db, d.ID); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func fetchItensByNFeID(db *sql.DB, nfeID int64) ([]nfe.ParsedItem, error) {
+	rows, err := db.Query(`
+		SELECT n_item, codigo, codigo_ean, descricao, ncm, cfop, unidade,
+		       quantidade, valor_unit, valor_total_bruto, valor_frete,
+		       valor_seguro, valor_desconto, valor_outros, ind_total,
+		       base_calculo_icms, valor_icms, base_calculo_icms_st,
+		       valor_icms_st, valor_ipi, valor_pis, valor_cofins
+		FROM nfe_item
+		WHERE nfe_id = $1
+		ORDER BY n_item
+	`, nfeID)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo itens da nfe_id=%d: %w", nfeID, err)
+	}
+	defer rows.Close()
+
+	var itens []nfe.ParsedItem
+	for rows.Next() {
+		var (
+			it                                          nfe.ParsedItem
+			codigo, codigoEAN, descricao, ncm, cfop, un sql.NullString
+			valorFrete, valorSeguro, valorDesconto      sql.NullFloat64
+			valorOutros, baseICMS, valorICMS            sql.NullFloat64
+			baseICMSST, valorICMSST                     sql.NullFloat64
+			valorIPI, valorPIS, valorCOFINS             sql.NullFloat64
+		)
+		if err := rows.Scan(
+			&it.NItem, &codigo, &codigoEAN, &descricao, &ncm, &cfop, &un,
+			&it.Quantidade, &it.ValorUnitario, &it.ValorTotalBruto, &valorFrete,
+			&valorSeguro, &valorDesconto, &valorOutros, &it.IndTotal,
+			&baseICMS, &valorICMS, &baseICMSST, &valorICMSST,
+			&valorIPI, &valorPIS, &valorCOFINS,
+		); err != nil {
+			return nil, fmt.Errorf("erro lendo item da nfe_id=%d: %w", nfeID, err)
+		}
+
+		it.Codigo = codigo.String
+		it.CodigoEAN = codigoEAN.String
+		it.Descricao = descricao.String
+		it.NCM = ncm.String
+		it.CFOP = cfop.String
+		it.Unidade = un.String
+		it.ValorFrete = valorFrete.Float64
+		it.ValorSeguro = valorSeguro.Float64
+		it.ValorDesconto = valorDesconto.Float64
+		it.ValorOutros = valorOutros.Float64
+		it.BaseCalculoICMS = baseICMS.Float64
+		it.ValorICMS = valorICMS.Float64
+		it.BaseCalculoICMSST = baseICMSST.Float64
+		it.ValorICMSST = valorICMSST.Float64
+		it.ValorIPI = valorIPI.Float64
+		it.ValorPIS = valorPIS.Float64
+		it.ValorCOFINS = valorCOFINS.Float64
+
+		itens = append(itens, it)
+	}
+	return itens, rows.Err()
+}
+
+func fetchDuplicatasByNFeID(db *sql.DB, nfeID int64) ([]nfe.ParsedDuplicata, error) {
+	rows, err := db.Query(`
+		SELECT numero_duplicata, data_vencimento, valor_duplicata
+		FROM nfe_duplicatas
+		WHERE nfe_id = $1
+		ORDER BY id
+	`, nfeID)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo duplicatas da nfe_id=%d: %w", nfeID, err)
+	}
+	defer rows.Close()
+
+	var dups []nfe.ParsedDuplicata
+	for rows.Next() {
+		var (
+			d      nfe.ParsedDuplicata
+			numero sql.NullString
+			venc   sql.NullTime
+		)
+		if err := rows.Scan(&numero, &venc, &d.Valor); err != nil {
+			return nil, fmt.Errorf("erro lendo duplicata da nfe_id=%d: %w", nfeID, err)
+		}
+		d.Numero = numero.String
+		if venc.Valid {
+			d.DataVencimento = venc.Time.Format("2006-01-02")
+		}
+		dups = append(dups, d)
+	}
+	return dups, rows.Err()
+}
+
+func fetchPagamentosByNFeID(db *sql.DB, nfeID int64) ([]nfe.ParsedPagamento, error) {
+	rows, err := db.Query(`
+		SELECT indicador_pagamento, meio_pagamento, valor_pagamento,
+		       cnpj_credenciadora, bandeira_cartao, codigo_autorizacao
+		FROM nfe_pagamentos
+		WHERE nfe_id = $1
+		ORDER BY id
+	`, nfeID)
+	if err != nil {
+		return nil, fmt.Errorf("erro lendo pagamentos da nfe_id=%d: %w", nfeID, err)
+	}
+	defer rows.Close()
+
+	var pags []nfe.ParsedPagamento
+	for rows.Next() {
+		var (
+			p                             nfe.ParsedPagamento
+			indicador                     sql.NullInt64
+			cnpjCred, bandeira, codigoAut sql.NullString
+		)
+		if err := rows.Scan(&indicador, &p.MeioPagamento, &p.Valor, &cnpjCred, &bandeira, &codigoAut); err != nil {
+			return nil, fmt.Errorf("erro lendo pagamento da nfe_id=%d: %w", nfeID, err)
+		}
+		if indicador.Valid {
+			v := int(indicador.Int64)
+			p.IndicadorPagamento = &v
+		}
+		p.CNPJCredenciadora = cnpjCred.String
+		p.BandeiraCartao = bandeira.String
+		p.CodigoAutorizacao = codigoAut.String
+		pags = append(pags, p)
+	}
+	return pags, rows.Err()
+}
+
 func insertNFe(tx *sql.Tx, p *nfe.ParsedNFe) (int64, error) {
 	var id int64
 
@@ -165,15 +945,27 @@ RETURNING id;
 	return id, nil
 }
 
-// nfe_xml: guarda o XML bruto + json (se um dia você quiser popular)
-func insertNFeXML(tx *sql.Tx, nfeID int64, p *nfe.ParsedNFe) error {
+// nfe_xml: guarda o XML bruto + json (se um dia você quiser popular). version
+// identifica a versão do XML para essa nfeID (1 na inserção inicial,
+// incrementando a cada reprocessamento que preserva histórico — ver
+// UpsertMode e nextXMLVersion).
+func insertNFeXML(tx *sql.Tx, nfeID int64, p *nfe.ParsedNFe, version int) error {
+	// emissao desnormalizada é NOT NULL desde a migration 0004 (sustenta a FK
+	// composta contra nfe(id, emissao); ver comentário no topo da migration).
+	emissao := strings.TrimSpace(p.EmissaoDate)
+	if emissao == "" {
+		return fmt.Errorf("emissao vazia para chave %s", p.ChaveAcesso)
+	}
+
 	const q = `
 INSERT INTO nfe_xml (
 	nfe_id,
+	emissao,
+	version,
 	xml_raw,
 	xml_json
 ) VALUES (
-	$1,$2,$3
+	$1,$2,$3,$4,$5
 );
 `
 	xmlRaw := string(p.XMLRaw)
@@ -181,17 +973,30 @@ INSERT INTO nfe_xml (
 	_, err := tx.Exec(
 		q,
 		nfeID,
+		emissao,
+		version,
 		xmlRaw,
 		nil, // por enquanto não estamos populando xml_json
 	)
 	if err != nil {
-		return fmt.Errorf("erro inserindo nfe_xml (nfe_id=%d): %w", nfeID, err)
+		return fmt.Errorf("erro inserindo nfe_xml (nfe_id=%d, version=%d): %w", nfeID, version, err)
 	}
 
 	return nil
 }
 
-func insertItens(tx *sql.Tx, nfeID int64, itens []nfe.ParsedItem) error {
+// nextXMLVersion devolve a próxima versão de nfe_xml a ser usada para nfeID
+// (1 se ainda não houver nenhuma linha).
+func nextXMLVersion(tx *sql.Tx, nfeID int64) (int, error) {
+	var version int
+	err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM nfe_xml WHERE nfe_id = $1`, nfeID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("erro calculando próxima versão de nfe_xml (nfe_id=%d): %w", nfeID, err)
+	}
+	return version, nil
+}
+
+func insertItens(tx *sql.Tx, nfeID int64, emissao string, itens []nfe.ParsedItem) error {
 	if len(itens) == 0 {
 		return nil
 	}
@@ -199,6 +1004,7 @@ func insertItens(tx *sql.Tx, nfeID int64, itens []nfe.ParsedItem) error {
 	const q = `
 INSERT INTO nfe_item (
 	nfe_id,
+	emissao,
 	n_item,
 	codigo,
 	codigo_ean,
@@ -222,9 +1028,9 @@ INSERT INTO nfe_item (
 	valor_pis,
 	valor_cofins
 ) VALUES (
-	$1,$2,$3,$4,$5,$6,$7,$8,
-	$9,$10,$11,$12,$13,$14,$15,$16,
-	$17,$18,$19,$20,$21,$22,$23
+	$1,$2,$3,$4,$5,$6,$7,$8,$9,
+	$10,$11,$12,$13,$14,$15,$16,$17,
+	$18,$19,$20,$21,$22,$23,$24
 );
 `
 
@@ -232,6 +1038,7 @@ INSERT INTO nfe_item (
 		_, err := tx.Exec(
 			q,
 			nfeID,
+			emissao,
 			it.NItem,
 			nullableString(it.Codigo),
 			nullableString(it.CodigoEAN),
@@ -263,7 +1070,7 @@ INSERT INTO nfe_item (
 	return nil
 }
 
-func insertDuplicatas(tx *sql.Tx, nfeID int64, dups []nfe.ParsedDuplicata) error {
+func insertDuplicatas(tx *sql.Tx, nfeID int64, emissao string, dups []nfe.ParsedDuplicata) error {
 	if len(dups) == 0 {
 		return nil
 	}
@@ -271,11 +1078,12 @@ func insertDuplicatas(tx *sql.Tx, nfeID int64, dups []nfe.ParsedDuplicata) error
 	const q = `
 INSERT INTO nfe_duplicatas (
 	nfe_id,
+	emissao,
 	numero_duplicata,
 	data_vencimento,
 	valor_duplicata
 ) VALUES (
-	$1,$2,$3,$4
+	$1,$2,$3,$4,$5
 );
 `
 
@@ -283,6 +1091,7 @@ INSERT INTO nfe_duplicatas (
 		_, err := tx.Exec(
 			q,
 			nfeID,
+			emissao,
 			nullableString(d.Numero),
 			toNullDate(d.DataVencimento),
 			d.Valor,
@@ -295,7 +1104,7 @@ INSERT INTO nfe_duplicatas (
 	return nil
 }
 
-func insertPagamentos(tx *sql.Tx, nfeID int64, pags []nfe.ParsedPagamento) error {
+func insertPagamentos(tx *sql.Tx, nfeID int64, emissao string, pags []nfe.ParsedPagamento) error {
 	if len(pags) == 0 {
 		return nil
 	}
@@ -303,6 +1112,7 @@ func insertPagamentos(tx *sql.Tx, nfeID int64, pags []nfe.ParsedPagamento) error
 	const q = `
 INSERT INTO nfe_pagamentos (
 	nfe_id,
+	emissao,
 	indicador_pagamento,
 	meio_pagamento,
 	valor_pagamento,
@@ -310,7 +1120,7 @@ INSERT INTO nfe_pagamentos (
 	bandeira_cartao,
 	codigo_autorizacao
 ) VALUES (
-	$1,$2,$3,$4,$5,$6,$7
+	$1,$2,$3,$4,$5,$6,$7,$8
 );
 `
 
@@ -325,6 +1135,7 @@ INSERT INTO nfe_pagamentos (
 		_, err := tx.Exec(
 			q,
 			nfeID,
+			emissao,
 			ind,
 			nullableString(p.MeioPagamento),
 			p.Valor,
@@ -359,6 +1170,22 @@ func nullableString(s string) interface{} {
 	return s
 }
 
+// copyNullableDate é o equivalente de toNullDate para o caminho de
+// pgx.CopyFrom: o protocolo binário do COPY exige um valor Go tipado por
+// coluna (não aceita a string crua "YYYY-MM-DD" que o Exec via protocolo
+// estendido tolera), então aqui o parse é feito explicitamente.
+func copyNullableDate(s string) interface{} {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
 func isUniqueViolation(err error) bool {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
@@ -0,0 +1,223 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"nfe-drop/internal/config"
+)
+
+// fsSource é o IngestSource padrão: observa IncomingDir via fsnotify,
+// espera cada arquivo estabilizar (writer terminar de escrever) e entrega
+// o path local como Object. É o comportamento histórico do watcher, só que
+// agora por trás da interface IngestSource.
+type fsSource struct {
+	cfg     *config.Config
+	watcher *fsnotify.Watcher
+
+	stableAttempts int
+	stableDelay    time.Duration
+
+	objects chan Object
+}
+
+func newFsSource(cfg *config.Config) (*fsSource, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsSource{
+		cfg:            cfg,
+		watcher:        fw,
+		stableAttempts: 5,
+		stableDelay:    200 * time.Millisecond,
+		objects:        make(chan Object),
+	}, nil
+}
+
+// Start assina IncomingDir, faz o scan inicial e começa a alimentar o
+// canal de objects em background.
+func (s *fsSource) Start(ctx context.Context) error {
+	if err := s.watcher.Add(s.cfg.IncomingDir); err != nil {
+		return err
+	}
+
+	slog.Info("watching diretório de entrada (backend fs)",
+		"incoming_dir", s.cfg.IncomingDir,
+	)
+
+	go func() {
+		s.processExistingFiles(ctx)
+		s.loop(ctx)
+	}()
+
+	return nil
+}
+
+func (s *fsSource) loop(ctx context.Context) {
+	defer close(s.objects)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			s.handleEvent(ctx, event)
+
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("erro no watcher fsnotify", "err", err)
+		}
+	}
+}
+
+func (s *fsSource) processExistingFiles(ctx context.Context) {
+	slog.Info("processando arquivos já existentes em incoming",
+		"incoming_dir", s.cfg.IncomingDir,
+	)
+
+	entries, err := os.ReadDir(s.cfg.IncomingDir)
+	if err != nil {
+		slog.Error("erro lendo diretório incoming",
+			"dir", s.cfg.IncomingDir,
+			"err", err,
+		)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		s.handlePath(ctx, filepath.Join(s.cfg.IncomingDir, entry.Name()))
+	}
+}
+
+func (s *fsSource) handleEvent(ctx context.Context, event fsnotify.Event) {
+	if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) == 0 {
+		return
+	}
+	s.handlePath(ctx, event.Name)
+}
+
+func (s *fsSource) handlePath(ctx context.Context, path string) {
+	filename := filepath.Base(path)
+
+	if isZoneIdentifier(filename) {
+		slog.Info("arquivo de metadata (Zone.Identifier) detectado; removendo",
+			"path", path,
+		)
+		if err := os.Remove(path); err != nil {
+			slog.Warn("falha ao remover arquivo de metadata",
+				"path", path,
+				"err", err,
+			)
+		}
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Debug("arquivo não está mais acessível, ignorando",
+				"path", path,
+				"err", err,
+			)
+		}
+		return
+	}
+	if info.IsDir() {
+		return
+	}
+
+	if !s.waitFileStable(path) {
+		slog.Warn("arquivo não estabilizou, ignorando por enquanto",
+			"path", path,
+		)
+		return
+	}
+
+	select {
+	case s.objects <- Object{Key: path, Path: path, Filename: filename}:
+	case <-ctx.Done():
+	}
+}
+
+func (s *fsSource) waitFileStable(path string) bool {
+	var lastSize int64 = -1
+
+	for i := 0; i < s.stableAttempts; i++ {
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return false
+			}
+			slog.Debug("erro ao stat arquivo durante espera de estabilidade",
+				"path", path,
+				"err", err,
+			)
+			return false
+		}
+
+		size := info.Size()
+		if size > 0 && size == lastSize {
+			return true
+		}
+
+		lastSize = size
+		time.Sleep(s.stableDelay)
+	}
+
+	return false
+}
+
+func (s *fsSource) Next(ctx context.Context) (Object, error) {
+	select {
+	case obj, ok := <-s.objects:
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return Object{}, err
+			}
+			return Object{}, errors.New("fsSource: canal de eventos encerrado")
+		}
+		return obj, nil
+	case <-ctx.Done():
+		return Object{}, ctx.Err()
+	}
+}
+
+// Ack não precisa fazer nada no backend fs: moveToProcessing já renomeou o
+// arquivo de IncomingDir para ProcessingDir antes do Ack ser chamado.
+func (s *fsSource) Ack(ctx context.Context, obj Object) error {
+	return nil
+}
+
+// Fail só loga: o arquivo continua em IncomingDir e será reconsiderado no
+// próximo evento fsnotify relevante (ou no scan de segurança, se houver).
+func (s *fsSource) Fail(ctx context.Context, obj Object, err error) error {
+	slog.Warn("falha processando arquivo de incoming (fs)", "path", obj.Path, "err", err)
+	return nil
+}
+
+func (s *fsSource) Close() error {
+	return s.watcher.Close()
+}
+
+func isZoneIdentifier(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.Contains(lower, "zone.identifier")
+}
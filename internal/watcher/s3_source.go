@@ -0,0 +1,240 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"nfe-drop/internal/config"
+)
+
+// s3PollInterval é o intervalo entre varreduras do bucket. O módulo não
+// assina notificações assíncronas do bucket (SQS/AMQP do MinIO) por
+// enquanto; o polling é suficiente pro volume de NF-e esperado e evita
+// depender de mais um ponto de configuração do bucket.
+const s3PollInterval = 10 * time.Second
+
+// s3Source implementa IngestSource lendo objetos de um bucket S3/MinIO
+// compatível: cada varredura lista o prefixo configurado, baixa pra TmpDir
+// os objetos ainda não vistos nesta execução e os entrega por Next como se
+// fossem arquivos locais recém-chegados em IncomingDir.
+type s3Source struct {
+	cfg    *config.Config
+	client *minio.Client
+
+	bucket          string
+	prefix          string
+	processedPrefix string
+
+	// seen evita rebaixar/reentregar um objeto a cada poll antes do Ack
+	// movê-lo pra processedPrefix (CopyObject+RemoveObject não é instantâneo
+	// aos olhos do próximo ListObjects). poll roda na goroutine de loop
+	// enquanto Ack/Fail são chamados pela goroutine principal do watcher
+	// (via Watcher.handleObject), então seen precisa de seenMu protegendo
+	// toda leitura/escrita/remoção.
+	seenMu sync.Mutex
+	seen   map[string]bool
+
+	objects chan Object
+}
+
+func newS3Source(cfg *config.Config) (*s3Source, error) {
+	endpoint := os.Getenv("NFE_DROP_S3_ENDPOINT")
+	if endpoint == "" {
+		return nil, fmt.Errorf("NFE_DROP_S3_ENDPOINT é obrigatório quando NFE_DROP_INGEST_BACKEND=s3")
+	}
+	bucket := os.Getenv("NFE_DROP_S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("NFE_DROP_S3_BUCKET é obrigatório quando NFE_DROP_INGEST_BACKEND=s3")
+	}
+
+	accessKey := os.Getenv("NFE_DROP_S3_ACCESS_KEY")
+	secretKey := os.Getenv("NFE_DROP_S3_SECRET_KEY")
+	prefix := os.Getenv("NFE_DROP_S3_PREFIX")
+	useSSL := strings.ToLower(os.Getenv("NFE_DROP_S3_USE_SSL")) == "true"
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro criando cliente S3/MinIO: %w", err)
+	}
+
+	return &s3Source{
+		cfg:             cfg,
+		client:          client,
+		bucket:          bucket,
+		prefix:          prefix,
+		processedPrefix: strings.TrimSuffix(prefix, "/") + "/processed/",
+		seen:            make(map[string]bool),
+		objects:         make(chan Object),
+	}, nil
+}
+
+func (s *s3Source) Start(ctx context.Context) error {
+	slog.Info("watching bucket S3/MinIO (backend s3)",
+		"bucket", s.bucket,
+		"prefix", s.prefix,
+		"poll_interval", s3PollInterval,
+	)
+
+	go s.loop(ctx)
+	return nil
+}
+
+func (s *s3Source) loop(ctx context.Context) {
+	defer close(s.objects)
+
+	ticker := time.NewTicker(s3PollInterval)
+	defer ticker.Stop()
+
+	s.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.poll(ctx)
+		}
+	}
+}
+
+func (s *s3Source) poll(ctx context.Context) {
+	opts := minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}
+
+	for obj := range s.client.ListObjects(ctx, s.bucket, opts) {
+		if obj.Err != nil {
+			slog.Error("erro listando objetos no bucket S3/MinIO",
+				"bucket", s.bucket, "prefix", s.prefix, "err", obj.Err)
+			continue
+		}
+		if obj.Key == "" || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if strings.HasPrefix(obj.Key, s.processedPrefix) {
+			continue
+		}
+		s.seenMu.Lock()
+		already := s.seen[obj.Key]
+		s.seenMu.Unlock()
+		if already {
+			continue
+		}
+
+		localPath, filename, err := s.download(ctx, obj.Key)
+		if err != nil {
+			slog.Error("erro baixando objeto do S3/MinIO",
+				"bucket", s.bucket, "key", obj.Key, "err", err)
+			continue
+		}
+
+		s.seenMu.Lock()
+		s.seen[obj.Key] = true
+		s.seenMu.Unlock()
+
+		select {
+		case s.objects <- Object{Key: obj.Key, Path: localPath, Filename: filename}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *s3Source) download(ctx context.Context, key string) (localPath, filename string, err error) {
+	filename = filepath.Base(key)
+	localPath = filepath.Join(s.cfg.TmpDir, filename)
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("erro abrindo objeto %q: %w", key, err)
+	}
+	defer obj.Close()
+
+	out, err := os.Create(localPath)
+	if err != nil {
+		return "", "", fmt.Errorf("erro criando arquivo local %q: %w", localPath, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, obj); err != nil {
+		return "", "", fmt.Errorf("erro baixando objeto %q para %q: %w", key, localPath, err)
+	}
+
+	return localPath, filename, nil
+}
+
+func (s *s3Source) Next(ctx context.Context) (Object, error) {
+	select {
+	case obj, ok := <-s.objects:
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return Object{}, err
+			}
+			return Object{}, fmt.Errorf("s3Source: canal de objetos encerrado")
+		}
+		return obj, nil
+	case <-ctx.Done():
+		return Object{}, ctx.Err()
+	}
+}
+
+// Ack move o objeto original pro prefixo "processed/" dentro do mesmo
+// bucket, em vez de apagá-lo — consistente com o backend fs, que move pra
+// ProcessedDir em vez de descartar o arquivo.
+func (s *s3Source) Ack(ctx context.Context, obj Object) error {
+	destKey := s.processedPrefix + path.Base(obj.Key)
+
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: destKey},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: obj.Key},
+	)
+	if err != nil {
+		return fmt.Errorf("erro copiando objeto %q para %q: %w", obj.Key, destKey, err)
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("erro removendo objeto original %q após mover para processed: %w", obj.Key, err)
+	}
+
+	s.seenMu.Lock()
+	delete(s.seen, obj.Key)
+	s.seenMu.Unlock()
+	if err := os.Remove(obj.Path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("falha ao remover cópia local do objeto S3/MinIO", "path", obj.Path, "err", err)
+	}
+
+	return nil
+}
+
+// Fail apenas libera o objeto do conjunto de "vistos": ele volta a aparecer
+// no próximo poll e é tentado de novo, sem um prefixo de erro dedicado (o
+// dead-letter da fila RabbitMQ já cobre falhas definitivas de parse).
+func (s *s3Source) Fail(ctx context.Context, obj Object, err error) error {
+	slog.Warn("falha processando objeto do S3/MinIO; será tentado novamente no próximo poll",
+		"bucket", s.bucket,
+		"key", obj.Key,
+		"err", err,
+	)
+	s.seenMu.Lock()
+	delete(s.seen, obj.Key)
+	s.seenMu.Unlock()
+	if rmErr := os.Remove(obj.Path); rmErr != nil && !os.IsNotExist(rmErr) {
+		slog.Warn("falha ao remover cópia local do objeto S3/MinIO", "path", obj.Path, "err", rmErr)
+	}
+	return nil
+}
+
+func (s *s3Source) Close() error {
+	return nil
+}
@@ -0,0 +1,44 @@
+package watcher
+
+import "context"
+
+// Object é um item bruto pronto pra ingestão, entregue por um IngestSource.
+// Path aponta pra uma cópia local já legível (o próprio arquivo em
+// IncomingDir no backend fs, ou uma cópia baixada em TmpDir no backend s3),
+// de onde o pipeline de waitFileStable/moveToProcessing consegue ler.
+type Object struct {
+	Key      string // identifica o objeto na origem (path local ou chave S3)
+	Path     string // caminho local de onde ler o conteúdo
+	Filename string
+}
+
+// IngestSource abstrai de onde os arquivos de entrada vêm: hoje fsnotify
+// num diretório local (fsSource) ou um bucket S3/MinIO (s3Source),
+// selecionados via NFE_DROP_INGEST_BACKEND=fs|s3. O Watcher não conhece os
+// detalhes de nenhum backend específico, só o contrato Next/Ack/Fail.
+type IngestSource interface {
+	// Start assina a origem (diretório fsnotify, bucket S3) e começa a
+	// alimentar Next em background. Precisa ser chamado antes do primeiro
+	// Next.
+	Start(ctx context.Context) error
+
+	// Next bloqueia até o próximo objeto estar disponível, ou até ctx ser
+	// cancelado.
+	Next(ctx context.Context) (Object, error)
+
+	// Ack é chamado depois que o watcher tratou o objeto: movido para
+	// ProcessingDir (o job é publicado na fila quando ela está habilitada;
+	// uma falha de publicação só é logada) ou, se a extensão não é
+	// suportada, encaminhado para IgnoredDir. Cabe ao backend decidir o que isso
+	// significa na origem: nada a fazer no fs (o rename já aconteceu), mover
+	// pro prefixo processed/ no S3.
+	Ack(ctx context.Context, obj Object) error
+
+	// Fail é chamado quando o objeto não pôde ser processado (o watcher não
+	// conseguiu movê-lo para ProcessingDir). Cabe ao backend decidir se tenta de novo no
+	// próximo ciclo ou descarta.
+	Fail(ctx context.Context, obj Object, err error) error
+
+	// Close libera os recursos do backend (watcher fsnotify, cliente S3).
+	Close() error
+}